@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/alex4108/jellycleaner/config"
+	"github.com/alex4108/jellycleaner/internal/audit"
+	"github.com/alex4108/jellycleaner/internal/notify"
+	"github.com/alex4108/jellycleaner/internal/radarr"
+	"github.com/alex4108/jellycleaner/internal/sonarr"
+	"github.com/alex4108/jellycleaner/internal/state"
+)
+
+// newAuditEntry describes a record being acted on, including its Jellyseerr request if there is one
+func (c *cleaner) newAuditEntry(ctx context.Context, record state.Record, library *config.Library, action string) audit.Entry {
+	entry := audit.Entry{
+		Action:   action,
+		ItemID:   record.ItemID,
+		Name:     record.Name,
+		Type:     record.Type,
+		Library:  record.Library,
+		Rule:     record.Rule,
+		Reason:   record.Reason,
+		MarkedAt: record.MarkedAt,
+		DueAt:    record.DueAt,
+	}
+	if entry.Library == "" {
+		entry.Library = libraryName(library)
+	}
+
+	switch record.Type {
+	case "Series", "Season":
+		entry.TVDBID = record.ExternalID
+	case "Movie":
+		entry.TMDBID = record.ExternalID
+	}
+
+	requests, err := c.requests(ctx)
+	if err != nil {
+		log.Warnf("Failed to get Jellyseerr requests for audit log: %v", err)
+		return entry
+	}
+	if request := requests.Find(jellyseerrMediaType(record.Type), record.ExternalID); request != nil {
+		entry.Request = &audit.Request{
+			ID:               request.ID,
+			Status:           request.Status,
+			RequestedByID:    request.RequestedBy.ID,
+			RequestedBy:      request.RequestedBy.Name,
+			RequestedByEmail: request.RequestedBy.Email,
+		}
+	}
+
+	return entry
+}
+
+// appendAudit writes an entry to the audit log. Failures are logged rather
+// than returned, since the action it describes has already happened.
+// Every executed action passes through here, so it is added to the digest too.
+func (c *cleaner) appendAudit(entry audit.Entry) {
+	if err := c.auditLog.Append(entry); err != nil {
+		log.Errorf("Failed to write audit log entry for %s: %v", entry.Name, err)
+	}
+
+	if c.digest != nil {
+		c.digest.Removed = append(c.digest.Removed, notify.Item{
+			Name:    entry.Name,
+			Type:    entry.Type,
+			Library: entry.Library,
+			Reason:  entry.Reason,
+			Action:  entry.Action,
+			Seasons: entry.Seasons,
+		})
+	}
+}
+
+func sonarrEntity(series *sonarr.Series) *audit.ArrEntity {
+	return &audit.ArrEntity{
+		Service:           "sonarr",
+		ID:                series.ID,
+		Title:             series.Title,
+		Year:              series.Year,
+		Path:              series.Path,
+		RootFolderPath:    series.RootFolderPath,
+		QualityProfileID:  series.QualityProfileID,
+		LanguageProfileID: series.LanguageProfileID,
+		Tags:              series.Tags,
+		Monitored:         series.Monitored,
+		SeasonFolder:      series.SeasonFolder,
+		SeriesType:        series.SeriesType,
+	}
+}
+
+func radarrEntity(movie *radarr.Movie) *audit.ArrEntity {
+	return &audit.ArrEntity{
+		Service:             "radarr",
+		ID:                  movie.ID,
+		Title:               movie.Title,
+		Year:                movie.Year,
+		Path:                movie.FilePath,
+		RootFolderPath:      movie.RootFolderPath,
+		QualityProfileID:    movie.QualityProfileID,
+		Tags:                movie.Tags,
+		Monitored:           movie.Monitored,
+		MinimumAvailability: movie.MinimumAvailability,
+	}
+}
@@ -3,58 +3,253 @@ package config
 import (
 	"fmt"
 	"io/ioutil"
+	"path/filepath"
 
+	"github.com/robfig/cron/v3"
 	"gopkg.in/yaml.v2"
 )
 
+// Job phases
+const (
+	PhaseMark   = "mark"   // Evaluate rules and mark/unmark items
+	PhaseDelete = "delete" // Delete items whose expiration date has passed
+)
+
 // Config represents the top-level configuration
 type Config struct {
-	Jellyfin          JellyfinConfig     `yaml:"jellyfin"`
-	Sonarr            SonarrConfig       `yaml:"sonarr"`
-	Radarr            RadarrConfig       `yaml:"radarr"`
-	Jellyseerr		 JellyseerrConfig    `yaml:"jellyseerr"`
-	HeadedOutPlaylist PlaylistConfig     `yaml:"headed_out_playlist"`
+	Jellyfin          JellyfinConfig      `yaml:"jellyfin"`
+	Sonarr            SonarrConfig        `yaml:"sonarr"`
+	Radarr            RadarrConfig        `yaml:"radarr"`
+	Jellyseerr        JellyseerrConfig    `yaml:"jellyseerr"`
+	HeadedOutPlaylist PlaylistConfig      `yaml:"headed_out_playlist"`
+	DryRun            bool                `yaml:"dry_run"`             // Report planned actions without executing them
+	RunTimeoutMinutes int                 `yaml:"run_timeout_minutes"` // Abort a cycle that runs longer; 0 means no limit
+	Workers           int                 `yaml:"workers"`             // Items evaluated concurrently; defaults to 4
+	Daemon            bool                `yaml:"daemon"`              // Keep running and repeat every CheckIntervalHours
+	Jobs              []Job               `yaml:"jobs"`                // Cron schedules for daemon mode, replaces CheckIntervalHours
+	DiskPressure      DiskPressure        `yaml:"disk_pressure"`
+	State             StateConfig         `yaml:"state"`
+	Audit             AuditConfig         `yaml:"audit"`
+	Recycle           RecycleConfig       `yaml:"recycle"`
+	Notifications     NotificationsConfig `yaml:"notifications"`
+	KeepVote          KeepVoteConfig      `yaml:"keep_vote"`
+}
+
+// StateConfig contains settings for the local state store
+type StateConfig struct {
+	Path string `yaml:"path"` // Defaults to state.json next to the config file
+}
+
+// Disk space sources
+const (
+	DiskSourceSonarr = "sonarr"
+	DiskSourceRadarr = "radarr"
+	DiskSourcePath   = "path"
+)
+
+// Disk pressure candidate scores
+const (
+	ScoreOldest       = "oldest"        // Added longest ago first
+	ScoreLeastWatched = "least_watched" // Fewest plays first
+	ScoreLastPlayed   = "last_played"   // Least recently played first
+)
+
+// DiskPressure marks additional items when free space drops below a target
+type DiskPressure struct {
+	Enabled      bool    `yaml:"enabled"`
+	Source       string  `yaml:"source"`         // "sonarr", "radarr" or "path"
+	Path         string  `yaml:"path"`           // Local path, or the Sonarr/Radarr disk to watch
+	TargetFreeGB float64 `yaml:"target_free_gb"` // Free space to reach
+	Score        string  `yaml:"score"`          // Candidate order; defaults to "oldest"
+}
+
+// Job runs a single phase on a cron schedule in daemon mode
+type Job struct {
+	Name     string `yaml:"name"`
+	Phase    string `yaml:"phase"`    // "mark" or "delete"
+	Schedule string `yaml:"schedule"` // Standard 5-field cron expression, e.g. "0 3 * * *"
+	Enabled  *bool  `yaml:"enabled"`  // Defaults to true
+}
+
+// IsEnabled reports whether the job should be scheduled
+func (j Job) IsEnabled() bool {
+	return j.Enabled == nil || *j.Enabled
 }
 
 // JellyfinConfig contains Jellyfin-specific configuration
 type JellyfinConfig struct {
 	URL       string     `yaml:"url"`
+	HTTP      HTTPConfig `yaml:"http"`
 	Libraries []Library  `yaml:"libraries"`
 }
 
+// JellyseerrConfig contains Jellyseerr-specific configuration
 type JellyseerrConfig struct {
-	URL       string     `yaml:"url"`
+	URL     string            `yaml:"url"`
+	HTTP    HTTPConfig        `yaml:"http"`
+	UserMap map[string]string `yaml:"user_map"` // Jellyseerr user (name, email or ID) to Jellyfin user (name or ID)
 }
 
+// Library actions, applied when a marked item expires
+const (
+	ActionDelete                  = "delete"                     // Delete the title from Sonarr/Radarr
+	ActionUnmonitor               = "unmonitor"                  // Keep the files, stop grabbing and upgrading
+	ActionUnmonitorAndDeleteFiles = "unmonitor_and_delete_files" // Keep the title, delete its files
+	ActionDowngrade               = "downgrade"                  // Switch to a smaller quality profile
+)
+
 // Library represents a single Jellyfin media library
 type Library struct {
-	Name       string        `yaml:"name"`
-	Type       string        `yaml:"type"` // "movie" or "series"
-	Rules      LibraryRules  `yaml:"rules"`
-	Exclusions []string      `yaml:"exclusions"`
+	Name       string       `yaml:"name"`
+	Type       string       `yaml:"type"` // "movie" or "series"
+	Rules      LibraryRules `yaml:"rules"`
+	Exclusions []string     `yaml:"exclusions"`
+	Action     string       `yaml:"action"` // What to do when an item expires; defaults to "delete"
+	// SeasonCleanup marks and removes the fully watched seasons of matching series instead of whole series
+	SeasonCleanup SeasonCleanup `yaml:"season_cleanup"`
+	// DeleteFiles removes the files along with the Sonarr/Radarr entry; defaults to true
+	DeleteFiles *bool `yaml:"delete_files"`
+	// AddImportListExclusion stops import lists from adding deleted titles again
+	AddImportListExclusion bool `yaml:"add_import_list_exclusion"`
+	// Downgrade configures the "downgrade" action
+	Downgrade Downgrade `yaml:"downgrade"`
+}
+
+// Downgrade replaces a title's files with a smaller version by switching it to
+// another quality profile and searching for it
+type Downgrade struct {
+	QualityProfile string `yaml:"quality_profile"` // Name of the Sonarr/Radarr quality profile to switch to
+	WaitDays       int    `yaml:"wait_days"`       // How long to wait for the smaller release; defaults to 14
+	OnTimeout      string `yaml:"on_timeout"`      // What happens once wait_days pass; defaults to "revert"
 }
 
-// LibraryRules defines conditions for marking content for deletion
+// What a downgrade does when no smaller release was imported in time
+const (
+	DowngradeTimeoutRevert = "revert" // Switch back to the original quality profile and unmark the title
+	DowngradeTimeoutSearch = "search" // Search again and keep waiting
+)
+
+// ShouldDeleteFiles reports whether deleting a title also deletes its files
+func (l Library) ShouldDeleteFiles() bool {
+	return l.DeleteFiles == nil || *l.DeleteFiles
+}
+
+// SeasonCleanup deletes the files of fully watched seasons and unmonitors them,
+// keeping the series and its future episodes in Sonarr
+type SeasonCleanup struct {
+	Enabled   bool           `yaml:"enabled"`
+	WatchedBy *WatchedByRule `yaml:"watched_by"` // Who must have watched a season; defaults to all enabled users
+}
+
+// LibraryRules defines conditions for marking content for deletion.
+// Conditions in the same block match if any of them match; All, Any and Not
+// nest further blocks to build combined policies.
 type LibraryRules struct {
-	DeleteIfWatchedByAll bool `yaml:"delete_if_watched_by_all"`
-	MaxAgeDays           int  `yaml:"max_age_days"`
+	DeleteIfWatchedByAll   bool           `yaml:"delete_if_watched_by_all"`
+	MaxAgeDays             int            `yaml:"max_age_days"`
+	NeverPlayed            bool           `yaml:"never_played"`
+	MaxDaysSinceLastPlayed int            `yaml:"max_days_since_last_played"` // Falls back to the added date if never played
+	WatchedBy              *WatchedByRule `yaml:"watched_by"`
+	WatchedByRequester     *RequesterRule `yaml:"watched_by_requester"`
+	All                    []LibraryRules `yaml:"all"`
+	Any                    []LibraryRules `yaml:"any"`
+	Not                    *LibraryRules  `yaml:"not"`
+}
+
+// WatchedByRule marks items watched by a chosen group of users.
+// Users are matched by name (case-insensitive) or ID.
+type WatchedByRule struct {
+	Users           []string `yaml:"users"`            // Users to consider; empty means all users
+	ExcludeUsers    []string `yaml:"exclude_users"`    // Users to ignore, e.g. admin or guest accounts
+	MinUsers        int      `yaml:"min_users"`        // How many of them must have watched it; 0 means all
+	IncludeDisabled bool     `yaml:"include_disabled"` // Count disabled accounts as well
+}
+
+// RequesterRule marks items once the user who requested them in Jellyseerr has watched them
+type RequesterRule struct {
+	GraceDays int `yaml:"grace_days"` // Days to wait after the requester last played the item
 }
 
 // SonarrConfig contains Sonarr-specific configuration
 type SonarrConfig struct {
-	URL    string `yaml:"url"`
+	URL  string     `yaml:"url"`
+	HTTP HTTPConfig `yaml:"http"`
 }
 
 // RadarrConfig contains Radarr-specific configuration
 type RadarrConfig struct {
-	URL    string `yaml:"url"`
+	URL  string     `yaml:"url"`
+	HTTP HTTPConfig `yaml:"http"`
+}
+
+// HTTPConfig tunes the requests made to a service. Transient failures (timeouts,
+// 429 and 5xx responses) are retried with exponential backoff.
+type HTTPConfig struct {
+	RateLimit      float64 `yaml:"rate_limit"`      // Maximum requests per second; 0 means unlimited
+	TimeoutSeconds int     `yaml:"timeout_seconds"` // Per attempt; defaults to 30
+	MaxRetries     int     `yaml:"max_retries"`     // Defaults to 3; -1 disables retries
 }
 
 // PlaylistConfig contains settings for the "Headed Out" playlist
 type PlaylistConfig struct {
-	Name              string `yaml:"name"`
+	Name               string `yaml:"name"`
 	CheckIntervalHours int    `yaml:"check_interval_hours"`
-	DeletionDelayDays int    `yaml:"deletion_delay_days"`
+	DeletionDelayDays  int    `yaml:"deletion_delay_days"`
+}
+
+// AuditConfig contains settings for the deletion audit log
+type AuditConfig struct {
+	Path string `yaml:"path"` // Defaults to audit.jsonl next to the config file
+}
+
+// RecycleConfig moves the files of deleted media into a holding directory
+// instead of deleting them, and purges them after RetentionDays
+type RecycleConfig struct {
+	Enabled       bool              `yaml:"enabled"`
+	Path          string            `yaml:"path"`           // Holding directory, on the same filesystem as the media
+	RetentionDays int               `yaml:"retention_days"` // Defaults to 30
+	PathMap       map[string]string `yaml:"path_map"`       // Sonarr/Radarr path prefix to local path prefix
+}
+
+// KeepVoteConfig lets users rescue items from "Headed Out" themselves, by
+// marking them as a favorite or adding them to their own "Keep" playlist
+type KeepVoteConfig struct {
+	Enabled        bool   `yaml:"enabled"`
+	Favorites      bool   `yaml:"favorites"`       // A Jellyfin favorite of any enabled user counts as a vote
+	Playlist       string `yaml:"playlist"`        // Name of the per-user playlist that counts as a vote
+	ProtectionDays int    `yaml:"protection_days"` // How long a rescued item can't be marked again; defaults to 30
+}
+
+// NotificationsConfig configures where the digest of each run is sent
+type NotificationsConfig struct {
+	Discord *WebhookConfig `yaml:"discord"`
+	Slack   *WebhookConfig `yaml:"slack"`
+	Webhook *WebhookConfig `yaml:"webhook"` // Generic JSON webhook
+	Email   *EmailConfig   `yaml:"email"`
+
+	// Requesters emails the Jellyseerr requester of each marked title, using the email settings
+	Requesters RequesterNotifications `yaml:"requesters"`
+}
+
+// RequesterNotifications tells the user who requested a title that it is headed out
+type RequesterNotifications struct {
+	Enabled          bool   `yaml:"enabled"`
+	KeepInstructions string `yaml:"keep_instructions"` // How to keep a title; a default text is used if empty
+}
+
+// WebhookConfig contains the URL notifications are posted to
+type WebhookConfig struct {
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"` // Extra headers, only used by the generic webhook
+}
+
+// EmailConfig contains SMTP settings. The password is read from SMTP_PASSWORD.
+type EmailConfig struct {
+	Host     string   `yaml:"host"`
+	Port     int      `yaml:"port"` // Defaults to 587
+	Username string   `yaml:"username"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
 }
 
 // LoadConfig reads and parses the configuration file
@@ -74,6 +269,12 @@ func LoadConfig(path string) (*Config, error) {
 	if err := validateConfig(&config); err != nil {
 		return nil, err
 	}
+	if config.State.Path == "" {
+		config.State.Path = filepath.Join(filepath.Dir(path), "state.json") // Set default
+	}
+	if config.Audit.Path == "" {
+		config.Audit.Path = filepath.Join(filepath.Dir(path), "audit.jsonl") // Set default
+	}
 
 	return &config, nil
 }
@@ -101,6 +302,155 @@ func validateConfig(config *Config) error {
 	if config.HeadedOutPlaylist.DeletionDelayDays == 0 {
 		config.HeadedOutPlaylist.DeletionDelayDays = 7 // Set default
 	}
+	if config.Workers == 0 {
+		config.Workers = 4 // Set default
+	} else if config.Workers < 0 {
+		return fmt.Errorf("workers must not be negative")
+	}
+	if config.RunTimeoutMinutes < 0 {
+		return fmt.Errorf("run_timeout_minutes must not be negative")
+	}
+	for service, http := range map[string]HTTPConfig{
+		"jellyfin":   config.Jellyfin.HTTP,
+		"sonarr":     config.Sonarr.HTTP,
+		"radarr":     config.Radarr.HTTP,
+		"jellyseerr": config.Jellyseerr.HTTP,
+	} {
+		if http.RateLimit < 0 || http.TimeoutSeconds < 0 {
+			return fmt.Errorf("%s.http: rate_limit and timeout_seconds must not be negative", service)
+		}
+	}
+	if config.DiskPressure.Enabled {
+		if err := validateDiskPressure(&config.DiskPressure); err != nil {
+			return fmt.Errorf("disk_pressure: %w", err)
+		}
+	}
+	for i := range config.Jellyfin.Libraries {
+		library := &config.Jellyfin.Libraries[i]
+		if library.SeasonCleanup.Enabled {
+			// Season cleanup replaces the action, so another one would be ignored
+			if library.Action != "" && library.Action != ActionDelete {
+				return fmt.Errorf("library %s: season_cleanup can't be combined with the %q action", library.Name, library.Action)
+			}
+			if !library.ShouldDeleteFiles() {
+				return fmt.Errorf("library %s: season_cleanup deletes files and can't be combined with delete_files: false", library.Name)
+			}
+		}
+		switch library.Action {
+		case "":
+			library.Action = ActionDelete // Set default
+		case ActionDelete, ActionUnmonitor, ActionUnmonitorAndDeleteFiles:
+		case ActionDowngrade:
+			if library.Downgrade.QualityProfile == "" {
+				return fmt.Errorf("library %s: downgrade.quality_profile is required for the %q action", library.Name, ActionDowngrade)
+			}
+			if library.Downgrade.WaitDays == 0 {
+				library.Downgrade.WaitDays = 14 // Set default
+			} else if library.Downgrade.WaitDays < 0 {
+				return fmt.Errorf("library %s: downgrade.wait_days must not be negative", library.Name)
+			}
+			switch library.Downgrade.OnTimeout {
+			case "":
+				library.Downgrade.OnTimeout = DowngradeTimeoutRevert // Set default
+			case DowngradeTimeoutRevert, DowngradeTimeoutSearch:
+			default:
+				return fmt.Errorf("library %s: downgrade.on_timeout must be %q or %q", library.Name, DowngradeTimeoutRevert, DowngradeTimeoutSearch)
+			}
+		default:
+			return fmt.Errorf("library %s: action must be %q, %q, %q or %q", library.Name, ActionDelete, ActionUnmonitor, ActionUnmonitorAndDeleteFiles, ActionDowngrade)
+		}
+	}
+	if config.Recycle.Enabled {
+		if config.Recycle.Path == "" {
+			return fmt.Errorf("recycle: path is required")
+		}
+		if config.Recycle.RetentionDays == 0 {
+			config.Recycle.RetentionDays = 30 // Set default
+		}
+	}
+	if config.KeepVote.Enabled {
+		if !config.KeepVote.Favorites && config.KeepVote.Playlist == "" {
+			return fmt.Errorf("keep_vote: favorites or playlist must be set")
+		}
+		if config.KeepVote.ProtectionDays == 0 {
+			config.KeepVote.ProtectionDays = 30 // Set default
+		}
+	}
+	if err := validateNotifications(&config.Notifications); err != nil {
+		return fmt.Errorf("notifications: %w", err)
+	}
+	if config.Notifications.Requesters.KeepInstructions == "" && config.KeepVote.Enabled {
+		config.Notifications.Requesters.KeepInstructions = keepVoteInstructions(config.KeepVote) // Set default
+	}
+	for i, job := range config.Jobs {
+		if job.Name == "" {
+			return fmt.Errorf("job %d: name is required", i)
+		}
+		if job.Phase != PhaseMark && job.Phase != PhaseDelete {
+			return fmt.Errorf("job %s: phase must be %q or %q", job.Name, PhaseMark, PhaseDelete)
+		}
+		if _, err := cron.ParseStandard(job.Schedule); err != nil {
+			return fmt.Errorf("job %s: invalid schedule: %w", job.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func validateDiskPressure(dp *DiskPressure) error {
+	switch dp.Source {
+	case DiskSourceSonarr, DiskSourceRadarr:
+	case DiskSourcePath:
+		if dp.Path == "" {
+			return fmt.Errorf("path is required when source is %q", DiskSourcePath)
+		}
+	default:
+		return fmt.Errorf("source must be %q, %q or %q", DiskSourceSonarr, DiskSourceRadarr, DiskSourcePath)
+	}
+	if dp.TargetFreeGB <= 0 {
+		return fmt.Errorf("target_free_gb must be greater than 0")
+	}
+	switch dp.Score {
+	case "":
+		dp.Score = ScoreOldest // Set default
+	case ScoreOldest, ScoreLeastWatched, ScoreLastPlayed:
+	default:
+		return fmt.Errorf("score must be %q, %q or %q", ScoreOldest, ScoreLeastWatched, ScoreLastPlayed)
+	}
+	return nil
+}
 
+func validateNotifications(n *NotificationsConfig) error {
+	for name, webhook := range map[string]*WebhookConfig{"discord": n.Discord, "slack": n.Slack, "webhook": n.Webhook} {
+		if webhook != nil && webhook.URL == "" {
+			return fmt.Errorf("%s: url is required", name)
+		}
+	}
+	if n.Email != nil {
+		if n.Email.Host == "" || n.Email.From == "" {
+			return fmt.Errorf("email: host and from are required")
+		}
+		if n.Email.Port == 0 {
+			n.Email.Port = 587 // Set default
+		}
+		if len(n.Email.To) == 0 && !n.Requesters.Enabled {
+			return fmt.Errorf("email: to is required unless requesters is enabled")
+		}
+	}
+	if n.Requesters.Enabled && n.Email == nil {
+		return fmt.Errorf("requesters: email must be configured")
+	}
 	return nil
-}
\ No newline at end of file
+}
+
+// keepVoteInstructions tells requesters how to rescue a title with a keep vote
+func keepVoteInstructions(kv KeepVoteConfig) string {
+	switch {
+	case kv.Favorites && kv.Playlist != "":
+		return fmt.Sprintf("To keep one, mark it as a favorite in Jellyfin or add it to your %q playlist before that date.", kv.Playlist)
+	case kv.Favorites:
+		return "To keep one, mark it as a favorite in Jellyfin before that date."
+	default:
+		return fmt.Sprintf("To keep one, add it to your %q playlist in Jellyfin before that date.", kv.Playlist)
+	}
+}
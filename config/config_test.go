@@ -0,0 +1,75 @@
+package config
+
+import "testing"
+
+func TestValidateNotifications(t *testing.T) {
+	email := func(to ...string) *EmailConfig {
+		return &EmailConfig{Host: "smtp.example.com", From: "jellycleaner@example.com", To: to}
+	}
+
+	tests := []struct {
+		name    string
+		cfg     NotificationsConfig
+		wantErr bool
+	}{
+		{"nothing configured", NotificationsConfig{}, false},
+		{"webhook", NotificationsConfig{Discord: &WebhookConfig{URL: "https://discord.com/api/webhooks/1/token"}}, false},
+		{"webhook without url", NotificationsConfig{Slack: &WebhookConfig{}}, true},
+		{"email digest", NotificationsConfig{Email: email("admin@example.com")}, false},
+		{"email without host", NotificationsConfig{Email: &EmailConfig{From: "jellycleaner@example.com", To: []string{"admin@example.com"}}}, true},
+		{"email without recipients", NotificationsConfig{Email: email()}, true},
+		{"requester emails only", NotificationsConfig{Email: email(), Requesters: RequesterNotifications{Enabled: true}}, false},
+		{"requesters without email", NotificationsConfig{Requesters: RequesterNotifications{Enabled: true}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			if err := validateNotifications(&cfg); (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateLibraryActions(t *testing.T) {
+	keepFiles := false
+
+	tests := []struct {
+		name       string
+		library    Library
+		wantErr    bool
+		wantAction string
+	}{
+		{"default action", Library{Name: "Movies"}, false, ActionDelete},
+		{"unmonitor", Library{Name: "Movies", Action: ActionUnmonitor}, false, ActionUnmonitor},
+		{"unknown action", Library{Name: "Movies", Action: "archive"}, true, ""},
+		{"downgrade without profile", Library{Name: "Movies", Action: ActionDowngrade}, true, ""},
+		{"downgrade", Library{Name: "Movies", Action: ActionDowngrade, Downgrade: Downgrade{QualityProfile: "HD-1080p", OnTimeout: DowngradeTimeoutSearch}}, false, ActionDowngrade},
+		{"downgrade with unknown timeout", Library{Name: "Movies", Action: ActionDowngrade, Downgrade: Downgrade{QualityProfile: "HD-1080p", OnTimeout: "delete"}}, true, ""},
+		{"season cleanup", Library{Name: "TV", SeasonCleanup: SeasonCleanup{Enabled: true}}, false, ActionDelete},
+		{"season cleanup with delete", Library{Name: "TV", Action: ActionDelete, SeasonCleanup: SeasonCleanup{Enabled: true}}, false, ActionDelete},
+		{"season cleanup with unmonitor", Library{Name: "TV", Action: ActionUnmonitor, SeasonCleanup: SeasonCleanup{Enabled: true}}, true, ""},
+		{"season cleanup with downgrade", Library{Name: "TV", Action: ActionDowngrade, Downgrade: Downgrade{QualityProfile: "SD"}, SeasonCleanup: SeasonCleanup{Enabled: true}}, true, ""},
+		{"season cleanup keeping files", Library{Name: "TV", DeleteFiles: &keepFiles, SeasonCleanup: SeasonCleanup{Enabled: true}}, true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{}
+			cfg.Jellyfin.URL = "http://jellyfin"
+			cfg.Sonarr.URL = "http://sonarr"
+			cfg.Radarr.URL = "http://radarr"
+			cfg.Jellyseerr.URL = "http://jellyseerr"
+			cfg.Jellyfin.Libraries = []Library{tt.library}
+
+			err := validateConfig(cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && cfg.Jellyfin.Libraries[0].Action != tt.wantAction {
+				t.Errorf("action = %q, want %q", cfg.Jellyfin.Libraries[0].Action, tt.wantAction)
+			}
+		})
+	}
+}
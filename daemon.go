@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/alex4108/jellycleaner/config"
+)
+
+// Replaced in tests so that cycles run instantly and intervals take milliseconds
+var (
+	cycleFunc    = runCycle
+	intervalUnit = time.Hour
+)
+
+// runDaemon keeps jellycleaner running until ctx is cancelled, which also
+// interrupts the cycle in progress. If jobs are configured they run on their
+// cron schedules, otherwise a full cycle runs every
+// headed_out_playlist.check_interval_hours. A reload that removes every job
+// falls back to the interval.
+func runDaemon(ctx context.Context, configPath string, cfg *config.Config, opts runOptions) {
+	if len(cfg.Jobs) > 0 {
+		s := &jobScheduler{
+			configPath: configPath,
+			opts:       opts,
+			cfg:        cfg,
+			reschedule: make(chan struct{}, 1),
+		}
+		if !s.run(ctx) {
+			return
+		}
+		log.Warn("Reloaded configuration has no jobs, falling back to a cycle every headed_out_playlist.check_interval_hours")
+		cfg = s.currentConfig()
+	}
+
+	runInterval(ctx, configPath, cfg, opts)
+}
+
+// runInterval runs a full cycle every headed_out_playlist.check_interval_hours.
+// The configuration is re-read before each cycle; if it fails to load, the
+// previous configuration is kept.
+func runInterval(ctx context.Context, configPath string, cfg *config.Config, opts runOptions) {
+	for {
+		// Cycles run on this goroutine, so a new one never starts while another is in progress
+		if err := cycleFunc(ctx, cfg, opts); err != nil {
+			log.Errorf("Cycle failed: %v", err)
+		} else {
+			log.Info("Cycle completed")
+		}
+
+		interval := time.Duration(cfg.HeadedOutPlaylist.CheckIntervalHours) * intervalUnit
+		log.Infof("Next cycle in %s", interval)
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			log.Info("Shutting down")
+			return
+		case <-timer.C:
+		}
+
+		cfg = reloadConfig(configPath, cfg)
+	}
+}
+
+// jobScheduler runs the configured jobs on their cron schedules
+type jobScheduler struct {
+	configPath string
+	opts       runOptions
+
+	// mu is held while a job runs, so that jobs never overlap
+	mu  sync.Mutex
+	cfg *config.Config
+
+	// reschedule is signalled when a reload changed the job definitions
+	reschedule chan struct{}
+}
+
+// run schedules the jobs until ctx is cancelled. It returns true if a reload
+// removed every job, so that the caller can fall back to the interval.
+func (s *jobScheduler) run(ctx context.Context) bool {
+	logger := cron.PrintfLogger(log.StandardLogger())
+
+	for {
+		jobs := s.currentConfig().Jobs
+		if len(jobs) == 0 {
+			return true
+		}
+
+		scheduler := cron.New(cron.WithLogger(logger))
+		for _, job := range jobs {
+			if !job.IsEnabled() {
+				log.Infof("Job %s is disabled", job.Name)
+				continue
+			}
+
+			job := job
+			wrapped := cron.NewChain(cron.SkipIfStillRunning(logger)).Then(cron.FuncJob(func() {
+				s.runJob(ctx, job)
+			}))
+			if _, err := scheduler.AddJob(job.Schedule, wrapped); err != nil {
+				log.Errorf("Failed to schedule job %s: %v", job.Name, err)
+				continue
+			}
+			log.Infof("Scheduled job %s (%s) at %q", job.Name, job.Phase, job.Schedule)
+		}
+		scheduler.Start()
+
+		select {
+		case <-ctx.Done():
+			log.Info("Shutting down, waiting for running jobs to stop")
+			<-scheduler.Stop().Done()
+			return false
+		case <-s.reschedule:
+			log.Info("Job definitions changed, rescheduling")
+			<-scheduler.Stop().Done()
+		}
+	}
+}
+
+func (s *jobScheduler) currentConfig() *config.Config {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cfg
+}
+
+// runJob reloads the configuration and runs the job's phase
+func (s *jobScheduler) runJob(ctx context.Context, job config.Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cfg := reloadConfig(s.configPath, s.cfg)
+	if !reflect.DeepEqual(cfg.Jobs, s.cfg.Jobs) {
+		select {
+		case s.reschedule <- struct{}{}:
+		default:
+		}
+	}
+	s.cfg = cfg
+
+	log.Infof("Running job %s (%s)", job.Name, job.Phase)
+	if err := cycleFunc(ctx, cfg, s.opts, job.Phase); err != nil {
+		log.Errorf("Job %s failed: %v", job.Name, err)
+		return
+	}
+	log.Infof("Job %s completed", job.Name)
+}
+
+// reloadConfig re-reads the configuration, keeping cfg if it fails to load
+func reloadConfig(configPath string, cfg *config.Config) *config.Config {
+	newCfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		log.Errorf("Failed to reload configuration, keeping previous one: %v", err)
+		return cfg
+	}
+	return newCfg
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alex4108/jellycleaner/config"
+)
+
+// stubDaemon makes cycles call fn and intervals last a millisecond per configured hour
+func stubDaemon(t *testing.T, fn func(ctx context.Context, cfg *config.Config, phases ...string) error) {
+	t.Helper()
+	origCycle, origUnit := cycleFunc, intervalUnit
+	cycleFunc = func(ctx context.Context, cfg *config.Config, opts runOptions, phases ...string) error {
+		return fn(ctx, cfg, phases...)
+	}
+	intervalUnit = time.Millisecond
+	t.Cleanup(func() {
+		cycleFunc, intervalUnit = origCycle, origUnit
+	})
+}
+
+// writeDaemonConfig writes a minimal valid configuration with the given extra YAML
+func writeDaemonConfig(t *testing.T, path, extra string) {
+	t.Helper()
+	data := `jellyfin:
+  url: http://jellyfin
+sonarr:
+  url: http://sonarr
+radarr:
+  url: http://radarr
+jellyseerr:
+  url: http://jellyseerr
+headed_out_playlist:
+  check_interval_hours: 1
+` + extra
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func loadDaemonConfig(t *testing.T, path string) *config.Config {
+	t.Helper()
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// waitDone fails the test if done isn't closed within a second
+func waitDone(t *testing.T, done <-chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("%s did not return", what)
+	}
+}
+
+func TestRunIntervalReloadsConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeDaemonConfig(t, path, "workers: 1\n")
+	cfg := loadDaemonConfig(t, path)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var workers []int
+	stubDaemon(t, func(ctx context.Context, cfg *config.Config, phases ...string) error {
+		workers = append(workers, cfg.Workers)
+		switch len(workers) {
+		case 1:
+			writeDaemonConfig(t, path, "workers: 2\n")
+		case 2:
+			// A broken configuration keeps the previous one
+			if err := os.WriteFile(path, []byte("jellyfin: ["), 0644); err != nil {
+				t.Error(err)
+			}
+		case 3:
+			cancel()
+		}
+		return nil
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runInterval(ctx, path, cfg, runOptions{})
+	}()
+	waitDone(t, done, "runInterval")
+
+	if fmt.Sprint(workers) != "[1 2 2]" {
+		t.Errorf("cycles saw workers %v, want [1 2 2]", workers)
+	}
+}
+
+func TestRunIntervalStopsOnCancel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	// A day-long interval, so only cancellation can end the wait
+	writeDaemonConfig(t, path, "")
+	cfg := loadDaemonConfig(t, path)
+	cfg.HeadedOutPlaylist.CheckIntervalHours = int(24 * time.Hour / time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var cycles int32
+	started := make(chan struct{})
+	stubDaemon(t, func(ctx context.Context, cfg *config.Config, phases ...string) error {
+		if atomic.AddInt32(&cycles, 1) == 1 {
+			close(started)
+		}
+		return nil
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runInterval(ctx, path, cfg, runOptions{})
+	}()
+
+	<-started
+	cancel()
+	waitDone(t, done, "runInterval")
+
+	if got := atomic.LoadInt32(&cycles); got != 1 {
+		t.Errorf("ran %d cycles, want 1", got)
+	}
+}
+
+func TestRunIntervalDoesNotOverlap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeDaemonConfig(t, path, "")
+	cfg := loadDaemonConfig(t, path)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Each cycle takes far longer than the one millisecond interval
+	var mu sync.Mutex
+	running, maxRunning, cycles := 0, 0, 0
+	stubDaemon(t, func(ctx context.Context, cfg *config.Config, phases ...string) error {
+		mu.Lock()
+		running++
+		cycles++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		last := cycles == 3
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		if last {
+			cancel()
+		}
+		return nil
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runInterval(ctx, path, cfg, runOptions{})
+	}()
+	waitDone(t, done, "runInterval")
+
+	mu.Lock()
+	defer mu.Unlock()
+	if maxRunning != 1 {
+		t.Errorf("%d cycles ran at once, want 1", maxRunning)
+	}
+	if cycles != 3 {
+		t.Errorf("ran %d cycles, want 3", cycles)
+	}
+}
+
+func TestRunJobReschedulesWhenJobsChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeDaemonConfig(t, path, `jobs:
+  - name: mark
+    phase: mark
+    schedule: "0 3 * * *"
+`)
+	cfg := loadDaemonConfig(t, path)
+
+	var phases []string
+	stubDaemon(t, func(ctx context.Context, cfg *config.Config, p ...string) error {
+		phases = append(phases, p...)
+		return nil
+	})
+
+	s := &jobScheduler{configPath: path, cfg: cfg, reschedule: make(chan struct{}, 1)}
+	job := cfg.Jobs[0]
+
+	s.runJob(context.Background(), job)
+	select {
+	case <-s.reschedule:
+		t.Fatal("rescheduled although the jobs did not change")
+	default:
+	}
+
+	writeDaemonConfig(t, path, `jobs:
+  - name: mark
+    phase: mark
+    schedule: "0 4 * * *"
+`)
+	s.runJob(context.Background(), job)
+	select {
+	case <-s.reschedule:
+	default:
+		t.Fatal("not rescheduled after the schedule changed")
+	}
+	if got := s.currentConfig().Jobs[0].Schedule; got != "0 4 * * *" {
+		t.Errorf("schedule = %q, want the reloaded one", got)
+	}
+	if fmt.Sprint(phases) != "[mark mark]" {
+		t.Errorf("ran phases %v, want [mark mark]", phases)
+	}
+}
+
+func TestRunDaemonFallsBackToIntervalWithoutJobs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeDaemonConfig(t, path, `jobs:
+  - name: mark
+    phase: mark
+    schedule: "@every 1s"
+  - name: delete
+    phase: delete
+    schedule: "@every 1s"
+    enabled: false
+`)
+	cfg := loadDaemonConfig(t, path)
+	// The first job reloads a configuration without any jobs
+	writeDaemonConfig(t, path, "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	var runs []string
+	stubDaemon(t, func(ctx context.Context, cfg *config.Config, phases ...string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(phases) == 0 {
+			// A full cycle only runs once the scheduler has given up
+			runs = append(runs, "cycle")
+			cancel()
+			return nil
+		}
+		runs = append(runs, phases...)
+		return nil
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runDaemon(ctx, path, cfg, runOptions{})
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("runDaemon did not return")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if fmt.Sprint(runs) != "[mark cycle]" {
+		t.Errorf("runs = %v, want [mark cycle]", runs)
+	}
+}
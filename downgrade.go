@@ -0,0 +1,382 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/alex4108/jellycleaner/config"
+	"github.com/alex4108/jellycleaner/internal/audit"
+	"github.com/alex4108/jellycleaner/internal/jellyfin"
+	"github.com/alex4108/jellycleaner/internal/plan"
+	"github.com/alex4108/jellycleaner/internal/sonarr"
+	"github.com/alex4108/jellycleaner/internal/state"
+)
+
+// downgradeContent shrinks a title in two steps. The first call switches it to
+// the library's downgrade quality profile and searches for it; Sonarr/Radarr
+// then replace the original files once a release that the profile ranks above
+// them is imported. Later calls check on the title and report true once every
+// original file has a replacement. Originals that were left next to their
+// replacement are recycled and deleted then. No file is removed before its
+// replacement has arrived, so a search that finds nothing loses nothing.
+//
+// A title that isn't replaced within downgrade.wait_days is searched for again
+// or switched back to its original profile, depending on downgrade.on_timeout.
+// Reverting also reports true, so that the title is unmarked.
+func (c *cleaner) downgradeContent(ctx context.Context, record state.Record, library *config.Library) (bool, error) {
+	item := recordItem(record)
+	profileName := library.Downgrade.QualityProfile
+
+	if c.plan != nil {
+		c.plan.Add(plan.Entry{
+			ItemID:         item.ID,
+			Item:           item.Name,
+			Type:           item.Type,
+			Library:        library.Name,
+			Rule:           record.Rule,
+			Reason:         record.Reason,
+			Action:         plan.ActionDowngrade,
+			ExpirationDate: record.DueAt.Format("2006-01-02"),
+		})
+		return true, nil
+	}
+
+	profileID, err := c.qualityProfileID(ctx, item.Type, profileName)
+	if err != nil {
+		return false, err
+	}
+
+	title, err := c.downgradeTitle(ctx, item)
+	if err != nil {
+		return false, err
+	}
+
+	if record.Downgrade == nil || record.Downgrade.SearchedAt.IsZero() {
+		return false, c.startDowngrade(ctx, record, title, profileID, profileName)
+	}
+
+	waiting, seasons, stale := title.progress(record.Downgrade)
+	if waiting {
+		if len(seasons) > 0 {
+			log.Infof("Waiting for a smaller release of %s to be imported (seasons %s)", item.Name, joinInts(seasons))
+		} else {
+			log.Infof("Waiting for a smaller release of %s to be imported", item.Name)
+		}
+		deadline := record.Downgrade.SearchedAt.AddDate(0, 0, library.Downgrade.WaitDays)
+		if time.Now().Before(deadline) {
+			return false, nil
+		}
+		return c.downgradeTimedOut(ctx, record, title, library)
+	}
+
+	entry := c.newAuditEntry(ctx, record, library, audit.ActionDowngrade)
+	entry.QualityProfile = profileName
+	entry.Arr = title.arr
+	entry.Arr.QualityProfileID = record.Downgrade.QualityProfileID
+	if len(stale) > 0 {
+		paths := make([]string, len(stale))
+		for i, file := range stale {
+			paths[i] = file.path
+		}
+		if entry.RecyclePath, err = c.recycleFiles(title.arr.Path, paths); err != nil {
+			return false, err
+		}
+		for _, file := range stale {
+			if err := c.deleteDowngradedFile(ctx, item.Type, file); err != nil {
+				return false, err
+			}
+		}
+	}
+	c.appendAudit(entry)
+
+	log.Infof("Successfully downgraded: %s (quality profile %s)", item.Name, profileName)
+	return true, nil
+}
+
+// downgradeTimedOut handles a title whose smaller release wasn't imported in
+// time. It searches again, or switches the title back to its original profile
+// and reports true so that it is unmarked.
+func (c *cleaner) downgradeTimedOut(ctx context.Context, record state.Record, title downgradeTitle, library *config.Library) (bool, error) {
+	if library.Downgrade.OnTimeout == config.DowngradeTimeoutSearch {
+		log.Warnf("No smaller release of %s was imported within %d days, searching again", record.Name, library.Downgrade.WaitDays)
+		if err := c.searchTitle(ctx, record.Type, title.arr.ID); err != nil {
+			return false, err
+		}
+		downgrade := *record.Downgrade
+		downgrade.SearchedAt = time.Now()
+		record.Downgrade = &downgrade
+		if err := c.store.Put(record); err != nil {
+			return false, fmt.Errorf("failed to record downgrade in state store: %w", err)
+		}
+		return false, nil
+	}
+
+	log.Warnf("No smaller release of %s was imported within %d days, switching back to its original quality profile", record.Name, library.Downgrade.WaitDays)
+	if err := c.setQualityProfile(ctx, record.Type, title.arr.ID, record.Downgrade.QualityProfileID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// downgradeFile is an episode or movie file of a title being downgraded
+type downgradeFile struct {
+	id   int
+	path string
+}
+
+// downgradeTitle is the Sonarr series or Radarr movie of a title being
+// downgraded, with its current files and, for series, episodes
+type downgradeTitle struct {
+	series   bool
+	arr      *audit.ArrEntity
+	files    []downgradeFile
+	episodes []sonarr.Episode
+}
+
+func (c *cleaner) downgradeTitle(ctx context.Context, item jellyfin.Item) (downgradeTitle, error) {
+	var title downgradeTitle
+	switch item.Type {
+	case "Series":
+		series, err := c.sonarrClient.GetSeriesByTVDBID(ctx, item.ExternalID)
+		if err != nil {
+			return title, fmt.Errorf("failed to find series in Sonarr: %w", err)
+		}
+		files, err := c.sonarrClient.GetEpisodeFiles(ctx, series.ID)
+		if err != nil {
+			return title, fmt.Errorf("failed to get episode files: %w", err)
+		}
+		if title.episodes, err = c.sonarrClient.GetEpisodes(ctx, series.ID); err != nil {
+			return title, fmt.Errorf("failed to get episodes: %w", err)
+		}
+		title.series = true
+		title.arr = sonarrEntity(series)
+		for _, file := range files {
+			title.files = append(title.files, downgradeFile{id: file.ID, path: file.Path})
+		}
+	case "Movie":
+		movie, err := c.radarrClient.GetMovieByTMDBID(ctx, item.ExternalID)
+		if err != nil {
+			return title, fmt.Errorf("failed to find movie in Radarr: %w", err)
+		}
+		files, err := c.radarrClient.GetMovieFiles(ctx, movie.ID)
+		if err != nil {
+			return title, fmt.Errorf("failed to get movie files: %w", err)
+		}
+		title.arr = radarrEntity(movie)
+		for _, file := range files {
+			title.files = append(title.files, downgradeFile{id: file.ID, path: file.Path})
+		}
+	default:
+		return title, fmt.Errorf("unsupported item type %q", item.Type)
+	}
+	return title, nil
+}
+
+// progress compares a title with the files it had when its downgrade started.
+// It reports whether any original is still waiting for its replacement, the
+// seasons those originals are in, and the originals that can go because every
+// episode they covered points at a new file. For movies, any new file
+// replaces the originals.
+func (t downgradeTitle) progress(downgrade *state.Downgrade) (bool, []int, []downgradeFile) {
+	replaced := make(map[int]bool)
+	waiting := false
+	var seasons []int
+
+	if t.series {
+		episodes := make(map[int]sonarr.Episode, len(t.episodes))
+		for _, episode := range t.episodes {
+			episodes[episode.ID] = episode
+		}
+		for episodeID, fileID := range downgrade.EpisodeFileIDs {
+			if _, ok := replaced[fileID]; !ok {
+				replaced[fileID] = true
+			}
+			episode, ok := episodes[episodeID]
+			if !ok {
+				continue // The episode was removed from Sonarr
+			}
+			if episode.EpisodeFileID == 0 || episode.EpisodeFileID == fileID {
+				replaced[fileID] = false
+				waiting = true
+				if !containsInt(seasons, episode.SeasonNumber) {
+					seasons = append(seasons, episode.SeasonNumber)
+				}
+			}
+		}
+		sort.Ints(seasons)
+	} else {
+		original := make(map[int]bool, len(downgrade.FileIDs))
+		for _, id := range downgrade.FileIDs {
+			original[id] = true
+		}
+		waiting = len(downgrade.FileIDs) > 0
+		for _, file := range t.files {
+			if !original[file.id] {
+				waiting = false
+			}
+		}
+		for _, id := range downgrade.FileIDs {
+			replaced[id] = !waiting
+		}
+	}
+
+	var stale []downgradeFile
+	for _, file := range t.files {
+		if replaced[file.id] {
+			stale = append(stale, file)
+		}
+	}
+	return waiting, seasons, stale
+}
+
+// startDowngrade remembers a title's quality profile and files in its record,
+// switches it to the downgrade profile and searches for it. The record is
+// saved first, so that a retry after a failed search still knows the original
+// profile.
+func (c *cleaner) startDowngrade(ctx context.Context, record state.Record, title downgradeTitle, profileID int, profileName string) error {
+	var downgrade state.Downgrade
+	if record.Downgrade != nil {
+		downgrade = *record.Downgrade
+	} else {
+		downgrade.QualityProfileID = title.arr.QualityProfileID
+		if record.Type == "Series" {
+			downgrade.EpisodeFileIDs = make(map[int]int)
+			for _, episode := range title.episodes {
+				if episode.EpisodeFileID != 0 {
+					downgrade.EpisodeFileIDs[episode.ID] = episode.EpisodeFileID
+				}
+			}
+		} else {
+			for _, file := range title.files {
+				downgrade.FileIDs = append(downgrade.FileIDs, file.id)
+			}
+		}
+		record.Downgrade = &downgrade
+		if err := c.store.Put(record); err != nil {
+			return fmt.Errorf("failed to record downgrade in state store: %w", err)
+		}
+	}
+
+	if title.arr.QualityProfileID != profileID {
+		if err := c.setQualityProfile(ctx, record.Type, title.arr.ID, profileID); err != nil {
+			return err
+		}
+	}
+	if err := c.searchTitle(ctx, record.Type, title.arr.ID); err != nil {
+		return err
+	}
+
+	downgrade.SearchedAt = time.Now()
+	record.Downgrade = &downgrade
+	if err := c.store.Put(record); err != nil {
+		return fmt.Errorf("failed to record downgrade in state store: %w", err)
+	}
+
+	log.Infof("Switched %s to quality profile %s, its files go once a smaller release is imported", record.Name, profileName)
+	return nil
+}
+
+// setQualityProfile switches a Sonarr series or Radarr movie to another quality profile
+func (c *cleaner) setQualityProfile(ctx context.Context, itemType string, arrID, profileID int) error {
+	if itemType == "Series" {
+		if err := c.sonarrClient.SetSeriesQualityProfile(ctx, arrID, profileID); err != nil {
+			return fmt.Errorf("failed to change quality profile in Sonarr: %w", err)
+		}
+		return nil
+	}
+	if err := c.radarrClient.SetMovieQualityProfile(ctx, arrID, profileID); err != nil {
+		return fmt.Errorf("failed to change quality profile in Radarr: %w", err)
+	}
+	return nil
+}
+
+// searchTitle has Sonarr search for a series or Radarr for a movie
+func (c *cleaner) searchTitle(ctx context.Context, itemType string, arrID int) error {
+	if itemType == "Series" {
+		if err := c.sonarrClient.SearchSeries(ctx, arrID); err != nil {
+			return fmt.Errorf("failed to search for series: %w", err)
+		}
+		return nil
+	}
+	if err := c.radarrClient.SearchMovie(ctx, arrID); err != nil {
+		return fmt.Errorf("failed to search for movie: %w", err)
+	}
+	return nil
+}
+
+// deleteDowngradedFile deletes an original file that Sonarr/Radarr kept after importing its replacement
+func (c *cleaner) deleteDowngradedFile(ctx context.Context, itemType string, file downgradeFile) error {
+	var err error
+	if itemType == "Series" {
+		err = c.sonarrClient.DeleteEpisodeFile(ctx, file.id)
+	} else {
+		err = c.radarrClient.DeleteMovieFile(ctx, file.id)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete original file %s: %w", file.path, err)
+	}
+	return nil
+}
+
+// joinInts formats numbers as a comma separated list
+func joinInts(values []int) string {
+	parts := make([]string, len(values))
+	for i, value := range values {
+		parts[i] = strconv.Itoa(value)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// qualityProfileID looks up a quality profile by name (case-insensitive) in
+// Sonarr for series or Radarr for movies
+func (c *cleaner) qualityProfileID(ctx context.Context, itemType, name string) (int, error) {
+	c.qualityProfilesMu.Lock()
+	defer c.qualityProfilesMu.Unlock()
+
+	key := itemType + ":" + strings.ToLower(name)
+	if id, ok := c.qualityProfiles[key]; ok {
+		return id, nil
+	}
+
+	var id int
+	found := false
+	switch itemType {
+	case "Series":
+		profiles, err := c.sonarrClient.GetQualityProfiles(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get quality profiles from Sonarr: %w", err)
+		}
+		for _, profile := range profiles {
+			if strings.EqualFold(profile.Name, name) {
+				id, found = profile.ID, true
+				break
+			}
+		}
+	case "Movie":
+		profiles, err := c.radarrClient.GetQualityProfiles(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get quality profiles from Radarr: %w", err)
+		}
+		for _, profile := range profiles {
+			if strings.EqualFold(profile.Name, name) {
+				id, found = profile.ID, true
+				break
+			}
+		}
+	}
+	if !found {
+		return 0, fmt.Errorf("quality profile %q not found", name)
+	}
+
+	if c.qualityProfiles == nil {
+		c.qualityProfiles = make(map[string]int)
+	}
+	c.qualityProfiles[key] = id
+	return id, nil
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alex4108/jellycleaner/config"
+	"github.com/alex4108/jellycleaner/internal/audit"
+	"github.com/alex4108/jellycleaner/internal/httpx"
+	"github.com/alex4108/jellycleaner/internal/jellyseerr"
+	"github.com/alex4108/jellycleaner/internal/radarr"
+	"github.com/alex4108/jellycleaner/internal/sonarr"
+	"github.com/alex4108/jellycleaner/internal/state"
+)
+
+func TestDowngradeKeepsFilesUntilReplaced(t *testing.T) {
+	dir := t.TempDir()
+	movieDir := filepath.Join(dir, "movies", "Old Movie")
+	originalPath := filepath.Join(movieDir, "old.mkv")
+	if err := os.MkdirAll(movieDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(originalPath, []byte("movie"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var calls []string
+	profileID := 4
+	files := []map[string]interface{}{{"id": 7, "movieId": 1, "path": originalPath}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		call := r.Method + " " + r.URL.Path
+		calls = append(calls, call)
+
+		var response interface{} = map[string]interface{}{}
+		switch call {
+		case "GET /api/v3/movie":
+			response = []map[string]interface{}{{"id": 1, "title": "Old Movie", "tmdbId": 11, "path": movieDir, "qualityProfileId": profileID}}
+		case "GET /api/v3/movie/1":
+			response = map[string]interface{}{"id": 1, "title": "Old Movie", "qualityProfileId": profileID}
+		case "PUT /api/v3/movie/1":
+			var body map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&body)
+			profileID = int(body["qualityProfileId"].(float64))
+		case "GET /api/v3/qualityprofile":
+			response = []map[string]interface{}{{"id": 4, "name": "Ultra-HD"}, {"id": 5, "name": "HD-1080p"}}
+		case "GET /api/v3/moviefile":
+			response = files
+		case "DELETE /api/v3/moviefile/7":
+			if _, err := os.Stat(originalPath); err == nil {
+				t.Error("original was deleted through Radarr before it was recycled")
+			}
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	radarrClient, err := radarr.NewClient(server.URL, "key", httpx.Options{MaxRetries: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := state.Open(filepath.Join(dir, "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{}
+	cfg.Recycle = config.RecycleConfig{Enabled: true, Path: filepath.Join(dir, "recycle"), RetentionDays: 30}
+	c := &cleaner{
+		cfg:          cfg,
+		radarrClient: radarrClient,
+		store:        store,
+		auditLog:     audit.Open(filepath.Join(dir, "audit.jsonl")),
+		requestIndex: jellyseerr.NewRequestIndex(nil),
+	}
+	library := &config.Library{Name: "Movies", Action: config.ActionDowngrade, Downgrade: config.Downgrade{QualityProfile: "HD-1080p", WaitDays: 14, OnTimeout: config.DowngradeTimeoutRevert}}
+	record := state.Record{ItemID: "m1", Name: "Old Movie", Type: "Movie", ExternalID: "11", Library: "Movies", DueAt: time.Now()}
+	if err := store.Put(record); err != nil {
+		t.Fatal(err)
+	}
+
+	// downgrade runs the action on the stored record, like processItemsDueForDeletion
+	downgrade := func() bool {
+		t.Helper()
+		record, _ := store.Get("m1")
+		done, err := c.downgradeContent(context.Background(), record, library)
+		if err != nil {
+			t.Fatalf("downgradeContent: %v", err)
+		}
+		return done
+	}
+	entries := func() []audit.Entry {
+		t.Helper()
+		entries, err := c.auditLog.Entries()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return entries
+	}
+
+	// The profile is switched and searched, but nothing is deleted yet
+	if downgrade() {
+		t.Fatal("downgrade finished before a replacement was imported")
+	}
+	if got := strings.Join(calls, ", "); !strings.Contains(got, "PUT /api/v3/movie/1") || !strings.Contains(got, "POST /api/v3/command") {
+		t.Errorf("want the profile switched and a search sent, got %s", got)
+	}
+	if stored, _ := store.Get("m1"); stored.Downgrade == nil || stored.Downgrade.QualityProfileID != 4 || len(stored.Downgrade.FileIDs) != 1 {
+		t.Errorf("downgrade wasn't recorded with the original profile and files: %+v", stored.Downgrade)
+	}
+
+	// Nothing happens while the search hasn't found anything
+	if downgrade() {
+		t.Fatal("downgrade finished before a replacement was imported")
+	}
+	for _, call := range calls {
+		if strings.HasPrefix(call, "DELETE") {
+			t.Fatalf("%s was sent before a replacement was imported", call)
+		}
+	}
+	if len(entries()) != 0 {
+		t.Fatal("audit entry was written before the downgrade finished")
+	}
+
+	// Once the replacement is imported, the original left next to it is recycled and deleted
+	mu.Lock()
+	files = append(files, map[string]interface{}{"id": 8, "movieId": 1, "path": filepath.Join(movieDir, "new.mkv")})
+	mu.Unlock()
+	if !downgrade() {
+		t.Fatal("downgrade didn't finish after the replacement was imported")
+	}
+	if !strings.Contains(strings.Join(calls, ", "), "DELETE /api/v3/moviefile/7") {
+		t.Error("original file wasn't deleted")
+	}
+
+	logged := entries()
+	if len(logged) != 1 {
+		t.Fatalf("want 1 audit entry, got %d", len(logged))
+	}
+	entry := logged[0]
+	if entry.Arr == nil || entry.Arr.QualityProfileID != 4 {
+		t.Errorf("audit entry doesn't keep the original profile: %+v", entry.Arr)
+	}
+	if _, err := os.Stat(filepath.Join(entry.RecyclePath, "old.mkv")); err != nil {
+		t.Errorf("original isn't in the recorded recycle path %q: %v", entry.RecyclePath, err)
+	}
+}
+
+func TestDowngradeSeriesWaitsForEveryEpisode(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	profileID := 4
+	episodeFiles := map[int]int{101: 11, 102: 12, 103: 13} // Episode ID to file ID
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		call := r.Method + " " + r.URL.Path
+		calls = append(calls, call)
+
+		var response interface{} = map[string]interface{}{}
+		switch call {
+		case "GET /api/v3/series":
+			response = []map[string]interface{}{{"id": 1, "title": "Old Show", "tvdbId": 81189, "qualityProfileId": profileID}}
+		case "GET /api/v3/series/1":
+			response = map[string]interface{}{"id": 1, "title": "Old Show", "qualityProfileId": profileID}
+		case "PUT /api/v3/series/1":
+			var body map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&body)
+			profileID = int(body["qualityProfileId"].(float64))
+		case "GET /api/v3/qualityprofile":
+			response = []map[string]interface{}{{"id": 4, "name": "Ultra-HD"}, {"id": 5, "name": "HD-1080p"}}
+		case "GET /api/v3/episode":
+			episodes := []map[string]interface{}{}
+			for episodeID, fileID := range episodeFiles {
+				episodes = append(episodes, map[string]interface{}{"id": episodeID, "seriesId": 1, "seasonNumber": 1, "episodeFileId": fileID})
+			}
+			response = episodes
+		case "GET /api/v3/episodefile":
+			files := []map[string]interface{}{}
+			for _, fileID := range episodeFiles {
+				files = append(files, map[string]interface{}{"id": fileID, "seriesId": 1, "seasonNumber": 1, "path": "/tv/Old Show/" + strconv.Itoa(fileID) + ".mkv"})
+			}
+			response = files
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	sonarrClient, err := sonarr.NewClient(server.URL, "key", httpx.Options{MaxRetries: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	store, err := state.Open(filepath.Join(dir, "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &cleaner{
+		cfg:          &config.Config{},
+		sonarrClient: sonarrClient,
+		store:        store,
+		auditLog:     audit.Open(filepath.Join(dir, "audit.jsonl")),
+		requestIndex: jellyseerr.NewRequestIndex(nil),
+	}
+	library := &config.Library{Name: "TV", Action: config.ActionDowngrade, Downgrade: config.Downgrade{QualityProfile: "HD-1080p", WaitDays: 14, OnTimeout: config.DowngradeTimeoutRevert}}
+	if err := store.Put(state.Record{ItemID: "s1", Name: "Old Show", Type: "Series", ExternalID: "81189", Library: "TV", DueAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	downgrade := func() bool {
+		t.Helper()
+		record, _ := store.Get("s1")
+		done, err := c.downgradeContent(context.Background(), record, library)
+		if err != nil {
+			t.Fatalf("downgradeContent: %v", err)
+		}
+		return done
+	}
+
+	if downgrade() {
+		t.Fatal("downgrade finished before a replacement was imported")
+	}
+	if profileID != 5 {
+		t.Fatalf("series has quality profile %d, want the downgrade profile", profileID)
+	}
+
+	// Sonarr replaces the first episode only; the others must keep their files
+	mu.Lock()
+	episodeFiles[101] = 21
+	mu.Unlock()
+	if downgrade() {
+		t.Fatal("downgrade finished with two episodes still waiting for a replacement")
+	}
+	for _, call := range calls {
+		if strings.HasPrefix(call, "DELETE") {
+			t.Fatalf("%s was sent while episodes were still waiting for a replacement", call)
+		}
+	}
+
+	// Once wait_days pass, the series goes back to its original profile
+	record, _ := store.Get("s1")
+	record.Downgrade.SearchedAt = time.Now().AddDate(0, 0, -15)
+	if err := store.Put(record); err != nil {
+		t.Fatal(err)
+	}
+	if !downgrade() {
+		t.Fatal("downgrade wasn't given up after wait_days")
+	}
+	if profileID != 4 {
+		t.Errorf("series has quality profile %d, want its original profile 4 back", profileID)
+	}
+	if entries, err := c.auditLog.Entries(); err != nil || len(entries) != 0 {
+		t.Errorf("want no audit entry for a reverted downgrade, got %v (%v)", entries, err)
+	}
+}
@@ -2,4 +2,10 @@ module github.com/alex4108/jellycleaner
 
 go 1.20
 
-require gopkg.in/yaml.v2 v2.4.0
+require (
+	github.com/robfig/cron/v3 v3.0.1
+	github.com/sirupsen/logrus v1.9.3
+	gopkg.in/yaml.v2 v2.4.0
+)
+
+require golang.org/x/sys v0.7.0 // indirect
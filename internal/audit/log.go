@@ -0,0 +1,145 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+// Actions recorded in the audit log
+const (
+	ActionDelete       = "delete"
+	ActionDeleteSeason = "delete_season"
+	ActionRestore      = "restore"
+
+	ActionUnmonitor               = "unmonitor"
+	ActionUnmonitorAndDeleteFiles = "unmonitor_and_delete_files"
+	ActionDowngrade               = "downgrade"
+)
+
+// Entry records a single destructive action together with everything needed
+// to explain it and to re-add the title later
+type Entry struct {
+	Time    time.Time `json:"time"`
+	Action  string    `json:"action"`
+	ItemID  string    `json:"item_id"`
+	Name    string    `json:"name"`
+	Type    string    `json:"type"` // "Series" or "Movie"
+	TVDBID  string    `json:"tvdb_id,omitempty"`
+	TMDBID  string    `json:"tmdb_id,omitempty"`
+	Library string    `json:"library,omitempty"`
+	Rule    string    `json:"rule,omitempty"`
+	Reason  string    `json:"reason,omitempty"`
+	Seasons []int     `json:"seasons,omitempty"` // Season numbers for season cleanups
+
+	MarkedAt time.Time `json:"marked_at"`
+	DueAt    time.Time `json:"due_at"`
+
+	Arr     *ArrEntity `json:"arr,omitempty"`
+	Request *Request   `json:"jellyseerr_request,omitempty"`
+
+	RecyclePath    string `json:"recycle_path,omitempty"`    // Where the files were moved in recycle mode
+	QualityProfile string `json:"quality_profile,omitempty"` // Profile a downgrade switched to
+}
+
+// ArrEntity is a snapshot of the Sonarr series or Radarr movie before it was changed
+type ArrEntity struct {
+	Service             string `json:"service"` // "sonarr" or "radarr"
+	ID                  int    `json:"id"`
+	Title               string `json:"title"`
+	Year                int    `json:"year,omitempty"`
+	Path                string `json:"path"`
+	RootFolderPath      string `json:"root_folder_path"`
+	QualityProfileID    int    `json:"quality_profile_id"`
+	LanguageProfileID   int    `json:"language_profile_id,omitempty"`
+	Tags                []int  `json:"tags,omitempty"`
+	Monitored           bool   `json:"monitored"`
+	SeasonFolder        bool   `json:"season_folder,omitempty"`
+	SeriesType          string `json:"series_type,omitempty"`
+	MinimumAvailability string `json:"minimum_availability,omitempty"`
+}
+
+// Request describes the Jellyseerr request for the title
+type Request struct {
+	ID               int    `json:"id"`
+	Status           int    `json:"status"`
+	RequestedByID    int    `json:"requested_by_id"`
+	RequestedBy      string `json:"requested_by"`
+	RequestedByEmail string `json:"requested_by_email,omitempty"`
+}
+
+// Log is an append-only JSON Lines file of audit entries
+type Log struct {
+	path string
+	mu   sync.Mutex
+}
+
+// Open returns the audit log at path. The file is created on the first append.
+func Open(path string) *Log {
+	return &Log{path: path}
+}
+
+// Append writes an entry to the end of the log
+func (l *Log) Append(entry Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("error opening audit log: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("error writing audit log: %w", err)
+	}
+	return f.Sync()
+}
+
+// Entries reads every entry in the log, oldest first
+func (l *Log) Entries() ([]Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.Open(l.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening audit log: %w", err)
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("error parsing audit log line %d: %w", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading audit log: %w", err)
+	}
+
+	return entries, nil
+}
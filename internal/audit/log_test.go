@@ -0,0 +1,126 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testEntry(itemID string) Entry {
+	marked := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	return Entry{
+		Time:     marked.AddDate(0, 0, 14),
+		Action:   ActionDelete,
+		ItemID:   itemID,
+		Name:     "Item " + itemID,
+		Type:     "Series",
+		TVDBID:   "81189",
+		Library:  "TV",
+		Rule:     "max_age_days(30)",
+		Reason:   "Exceeds maximum age",
+		Seasons:  []int{1, 2},
+		MarkedAt: marked,
+		DueAt:    marked.AddDate(0, 0, 14),
+		Arr: &ArrEntity{
+			Service:          "sonarr",
+			ID:               7,
+			Title:            "Breaking Bad",
+			Path:             "/tv/Breaking Bad",
+			RootFolderPath:   "/tv",
+			QualityProfileID: 4,
+			Tags:             []int{1, 3},
+			Monitored:        true,
+			SeasonFolder:     true,
+			SeriesType:       "standard",
+		},
+		Request:     &Request{ID: 12, Status: 2, RequestedByID: 3, RequestedBy: "alice"},
+		RecyclePath: "/recycle/20240515T120000Z_Breaking Bad",
+	}
+}
+
+func TestEntriesMissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	entries, err := Open(path).Entries()
+	if err != nil {
+		t.Fatalf("Entries: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("got %d entries, want none", len(entries))
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("audit log exists before the first append: %v", err)
+	}
+}
+
+func TestAppendRoundTrip(t *testing.T) {
+	l := Open(filepath.Join(t.TempDir(), "audit.jsonl"))
+
+	want := []Entry{testEntry("a"), testEntry("b")}
+	want[1].Action = ActionRestore
+	for _, entry := range want {
+		if err := l.Append(entry); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+
+	got, err := l.Entries()
+	if err != nil {
+		t.Fatalf("Entries: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Entries() = %+v, want %+v", got, want)
+	}
+}
+
+func TestAppendSetsTime(t *testing.T) {
+	l := Open(filepath.Join(t.TempDir(), "audit.jsonl"))
+
+	entry := testEntry("a")
+	entry.Time = time.Time{}
+	before := time.Now()
+	if err := l.Append(entry); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+
+	entries, err := l.Entries()
+	if err != nil {
+		t.Fatalf("Entries: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Time.Before(before.Truncate(time.Second)) {
+		t.Errorf("Entries() = %+v, want one entry stamped with the current time", entries)
+	}
+}
+
+func TestAppendToExistingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	if err := Open(path).Append(testEntry("a")); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	// A later run opens the same file and must not truncate it
+	if err := Open(path).Append(testEntry("b")); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+
+	entries, err := Open(path).Entries()
+	if err != nil {
+		t.Fatalf("Entries: %v", err)
+	}
+	if len(entries) != 2 || entries[0].ItemID != "a" || entries[1].ItemID != "b" {
+		t.Errorf("Entries() = %+v, want a then b", entries)
+	}
+}
+
+func TestEntriesInvalidLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	if err := os.WriteFile(path, []byte("{\"item_id\":\"a\"}\n\nnot json\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Open(path).Entries(); err == nil {
+		t.Error("Entries() succeeded on a corrupt log")
+	}
+}
@@ -0,0 +1,8 @@
+// Package diskspace reports free space on local filesystems
+package diskspace
+
+// Usage describes the space on a filesystem, in bytes
+type Usage struct {
+	Free  uint64
+	Total uint64
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd
+
+package diskspace
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Get returns the space on the filesystem containing path
+func Get(path string) (Usage, error) {
+	return Usage{}, fmt.Errorf("reading disk space is not supported on %s", runtime.GOOS)
+}
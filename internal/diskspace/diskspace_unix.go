@@ -0,0 +1,18 @@
+//go:build linux || darwin || freebsd
+
+package diskspace
+
+import "syscall"
+
+// Get returns the space on the filesystem containing path
+func Get(path string) (Usage, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return Usage{}, err
+	}
+
+	return Usage{
+		Free:  uint64(stat.Bavail) * uint64(stat.Bsize),
+		Total: uint64(stat.Blocks) * uint64(stat.Bsize),
+	}, nil
+}
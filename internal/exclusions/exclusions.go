@@ -0,0 +1,145 @@
+// Package exclusions decides which items are never marked for deletion
+package exclusions
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/alex4108/jellycleaner/internal/jellyfin"
+)
+
+// Exclusion kinds, written as "<kind>:<value>". Entries without a known
+// prefix match the item name exactly.
+const (
+	KindName   = "name"    // Exact item name
+	KindGlob   = "glob"    // Item name with * and ? wildcards, case-insensitive
+	KindRegex  = "regex"   // Regular expression on the item name
+	KindTag    = "tag"     // Jellyfin tag, case-insensitive
+	KindArrTag = "arr_tag" // Sonarr/Radarr tag label, case-insensitive
+	KindTVDB   = "tvdb"    // TVDB ID of a series
+	KindTMDB   = "tmdb"    // TMDB ID of a movie
+)
+
+// Sources provide the item data that tag exclusions need. They are only
+// called if an exclusion of that kind is configured.
+type Sources struct {
+	Tags    func(ctx context.Context, item jellyfin.Item) ([]string, error) // Jellyfin tags
+	ArrTags func(ctx context.Context, item jellyfin.Item) ([]string, error) // Sonarr/Radarr tag labels
+}
+
+type exclusion struct {
+	raw   string
+	kind  string
+	value string
+	re    *regexp.Regexp
+}
+
+// Matcher checks items against a library's exclusions
+type Matcher struct {
+	exclusions []exclusion
+}
+
+// Compile parses a library's exclusions
+func Compile(entries []string) (*Matcher, error) {
+	m := &Matcher{}
+	for _, entry := range entries {
+		e := exclusion{raw: entry, kind: KindName, value: entry}
+		if kind, value, ok := strings.Cut(entry, ":"); ok {
+			switch kind {
+			case KindName, KindGlob, KindRegex, KindTag, KindArrTag, KindTVDB, KindTMDB:
+				e.kind, e.value = kind, strings.TrimSpace(value)
+			}
+		}
+
+		switch e.kind {
+		case KindGlob:
+			e.re = globRegexp(e.value)
+		case KindRegex:
+			re, err := regexp.Compile(e.value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid exclusion %q: %w", entry, err)
+			}
+			e.re = re
+		}
+		if e.value == "" {
+			return nil, fmt.Errorf("invalid exclusion %q: empty value", entry)
+		}
+
+		m.exclusions = append(m.exclusions, e)
+	}
+	return m, nil
+}
+
+// Match returns the first exclusion matching item, or "" if none does.
+// Errors fetching tags are returned together with any later match.
+func (m *Matcher) Match(ctx context.Context, item jellyfin.Item, sources Sources) (string, error) {
+	var tags, arrTags []string
+	var tagsLoaded, arrTagsLoaded bool
+	var firstErr error
+
+	for _, e := range m.exclusions {
+		matched := false
+		switch e.kind {
+		case KindName:
+			matched = item.Name == e.value
+		case KindGlob, KindRegex:
+			matched = e.re.MatchString(item.Name)
+		case KindTVDB:
+			matched = item.Type == "Series" && item.ExternalID == e.value
+		case KindTMDB:
+			matched = item.Type == "Movie" && item.ExternalID == e.value
+		case KindTag:
+			if !tagsLoaded && sources.Tags != nil {
+				var err error
+				if tags, err = sources.Tags(ctx, item); err != nil && firstErr == nil {
+					firstErr = fmt.Errorf("failed to get tags of %s: %w", item.Name, err)
+				}
+				tagsLoaded = true
+			}
+			matched = containsFold(tags, e.value)
+		case KindArrTag:
+			if !arrTagsLoaded && sources.ArrTags != nil {
+				var err error
+				if arrTags, err = sources.ArrTags(ctx, item); err != nil && firstErr == nil {
+					firstErr = fmt.Errorf("failed to get Sonarr/Radarr tags of %s: %w", item.Name, err)
+				}
+				arrTagsLoaded = true
+			}
+			matched = containsFold(arrTags, e.value)
+		}
+
+		if matched {
+			return e.raw, firstErr
+		}
+	}
+	return "", firstErr
+}
+
+// globRegexp converts a glob with * and ? wildcards into a case-insensitive regexp
+func globRegexp(glob string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("(?i)^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package exclusions
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/alex4108/jellycleaner/internal/jellyfin"
+)
+
+func TestMatch(t *testing.T) {
+	movie := jellyfin.Item{ID: "m1", Name: "The Dark Knight", Type: "Movie", ExternalID: "155"}
+	series := jellyfin.Item{ID: "s1", Name: "Breaking Bad", Type: "Series", ExternalID: "81189"}
+	colon := jellyfin.Item{ID: "m2", Name: "Star Wars: A New Hope", Type: "Movie", ExternalID: "11"}
+
+	sources := Sources{
+		Tags: func(ctx context.Context, item jellyfin.Item) ([]string, error) {
+			return map[string][]string{"m1": {"Keep", "4K"}}[item.ID], nil
+		},
+		ArrTags: func(ctx context.Context, item jellyfin.Item) ([]string, error) {
+			return map[string][]string{"s1": {"permanent"}}[item.ID], nil
+		},
+	}
+
+	tests := []struct {
+		name      string
+		exclusion string
+		item      jellyfin.Item
+		matched   bool
+	}{
+		{"exact name", "The Dark Knight", movie, true},
+		{"exact name is case-sensitive", "the dark knight", movie, false},
+		{"name prefix", "name:Breaking Bad", series, true},
+		{"name containing a colon", "Star Wars: A New Hope", colon, true},
+		{"glob", "glob:*dark*", movie, true},
+		{"glob is case-insensitive", "glob:THE DARK ?NIGHT", movie, true},
+		{"glob must match the whole name", "glob:Dark*", movie, false},
+		{"glob escapes metacharacters", "glob:Star Wars: A New Hope", colon, true},
+		{"regex", "regex:^Breaking", series, true},
+		{"regex is case-sensitive by default", "regex:^breaking", series, false},
+		{"regex with flags", "regex:(?i)^breaking", series, true},
+		{"tag", "tag:keep", movie, true},
+		{"missing tag", "tag:keep", series, false},
+		{"arr tag", "arr_tag:Permanent", series, true},
+		{"missing arr tag", "arr_tag:permanent", movie, false},
+		{"tvdb", "tvdb:81189", series, true},
+		{"tvdb doesn't match movies", "tvdb:155", movie, false},
+		{"tmdb", "tmdb:155", movie, true},
+		{"tmdb doesn't match series", "tmdb:81189", series, false},
+		{"value is trimmed", "tmdb: 155", movie, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := Compile([]string{tt.exclusion})
+			if err != nil {
+				t.Fatalf("Compile: %v", err)
+			}
+			got, err := m.Match(context.Background(), tt.item, sources)
+			if err != nil {
+				t.Fatalf("Match: %v", err)
+			}
+			if matched := got != ""; matched != tt.matched {
+				t.Errorf("matched = %v, want %v", matched, tt.matched)
+			}
+			if tt.matched && got != tt.exclusion {
+				t.Errorf("Match = %q, want %q", got, tt.exclusion)
+			}
+		})
+	}
+}
+
+func TestCompileRejectsInvalid(t *testing.T) {
+	tests := []string{
+		"regex:(unclosed",
+		"regex:",
+		"glob:",
+		"tag: ",
+		"tmdb:",
+	}
+
+	for _, entry := range tests {
+		if _, err := Compile([]string{"Valid", entry}); err == nil {
+			t.Errorf("Compile(%q) succeeded, want an error", entry)
+		}
+	}
+}
+
+func TestMatchReturnsFirstExclusion(t *testing.T) {
+	m, err := Compile([]string{"glob:*Bad", "tvdb:81189"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := jellyfin.Item{Name: "Breaking Bad", Type: "Series", ExternalID: "81189"}
+	if got, _ := m.Match(context.Background(), item, Sources{}); got != "glob:*Bad" {
+		t.Errorf("Match = %q, want the first exclusion", got)
+	}
+}
+
+func TestMatchLoadsTagsOnlyWhenNeeded(t *testing.T) {
+	calls := 0
+	sources := Sources{
+		Tags: func(ctx context.Context, item jellyfin.Item) ([]string, error) {
+			calls++
+			return nil, nil
+		},
+	}
+	item := jellyfin.Item{Name: "Archer", Type: "Series"}
+
+	m, err := Compile([]string{"Archer", "tag:keep"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Match(context.Background(), item, sources); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 0 {
+		t.Errorf("tags were loaded %d times before a name match, want 0", calls)
+	}
+
+	m, err = Compile([]string{"tag:keep", "tag:4k"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Match(context.Background(), item, sources); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Errorf("tags were loaded %d times, want 1", calls)
+	}
+}
+
+func TestMatchReportsTagErrors(t *testing.T) {
+	sources := Sources{
+		Tags: func(ctx context.Context, item jellyfin.Item) ([]string, error) {
+			return nil, errors.New("unavailable")
+		},
+	}
+	item := jellyfin.Item{Name: "Archer", Type: "Series", ExternalID: "110381"}
+
+	m, err := Compile([]string{"tag:keep", "tvdb:110381"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := m.Match(context.Background(), item, sources)
+	if err == nil {
+		t.Error("expected the tag error to be returned")
+	}
+	if got != "tvdb:110381" {
+		t.Errorf("Match = %q, want the later match to still apply", got)
+	}
+}
@@ -0,0 +1,305 @@
+// Package httpx is the HTTP transport shared by the service clients. It adds
+// retries with exponential backoff, rate limiting and errors that carry the
+// response status and body.
+package httpx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Options configure a Client. Zero values use the defaults.
+type Options struct {
+	Timeout     time.Duration // Per attempt; defaults to 30s
+	MaxRetries  int           // Retries after the first attempt; defaults to 3, negative disables retries
+	BaseBackoff time.Duration // Delay before the first retry; defaults to 500ms
+	MaxBackoff  time.Duration // Upper bound of the delay between retries; defaults to 10s
+	RateLimit   float64       // Maximum requests per second; 0 means unlimited
+
+	// Auth adds credentials to every request
+	Auth func(req *http.Request)
+}
+
+// Client sends JSON requests to a single service
+type Client struct {
+	baseURL    string
+	opts       Options
+	httpClient *http.Client
+	limiter    *limiter
+}
+
+// StatusError is returned when a service answers with a non-2xx status
+type StatusError struct {
+	Method     string
+	Endpoint   string
+	StatusCode int
+	Status     string
+	Body       string // Start of the response body
+}
+
+func (e *StatusError) Error() string {
+	msg := fmt.Sprintf("%s: API request failed with status: %s", describe(e.Method, e.Endpoint), e.Status)
+	if e.Body != "" {
+		msg += ": " + e.Body
+	}
+	return msg
+}
+
+// describe names a request in errors and logs. The endpoint is empty when
+// requests go to the base URL itself.
+func describe(method, endpoint string) string {
+	if endpoint == "" {
+		return method
+	}
+	return method + " " + endpoint
+}
+
+// IsStatus reports whether err is a StatusError with the given status code
+func IsStatus(err error, statusCode int) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == statusCode
+}
+
+// maxErrorBody is how much of a response body is kept in a StatusError
+const maxErrorBody = 1024
+
+// New creates a client for the service at baseURL
+func New(baseURL string, opts Options) *Client {
+	if opts.Timeout == 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = 3
+	}
+	if opts.BaseBackoff == 0 {
+		opts.BaseBackoff = 500 * time.Millisecond
+	}
+	if opts.MaxBackoff == 0 {
+		opts.MaxBackoff = 10 * time.Second
+	}
+
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		opts:       opts,
+		httpClient: &http.Client{Timeout: opts.Timeout},
+		limiter:    newLimiter(opts.RateLimit),
+	}
+}
+
+// Get sends a GET request and decodes the JSON response into response, if not nil
+func (c *Client) Get(ctx context.Context, endpoint string, response interface{}) error {
+	return c.Do(ctx, "GET", endpoint, nil, response)
+}
+
+// Post sends body as JSON and decodes the JSON response into response, if not nil
+func (c *Client) Post(ctx context.Context, endpoint string, body interface{}, response interface{}) error {
+	return c.Do(ctx, "POST", endpoint, body, response)
+}
+
+// Put sends body as JSON and decodes the JSON response into response, if not nil
+func (c *Client) Put(ctx context.Context, endpoint string, body interface{}, response interface{}) error {
+	return c.Do(ctx, "PUT", endpoint, body, response)
+}
+
+// Delete sends a DELETE request and decodes the JSON response into response, if not nil
+func (c *Client) Delete(ctx context.Context, endpoint string, response interface{}) error {
+	return c.Do(ctx, "DELETE", endpoint, nil, response)
+}
+
+// Do sends a request, retrying transient failures. GET, PUT and DELETE are
+// retried on timeouts, network errors, 429 and 5xx responses. Other methods,
+// such as POST, could be applied twice if a response is lost on the way back,
+// so they are only retried when the service can't have processed them: when
+// the connection couldn't be made, or on 429. Cancelling ctx aborts the
+// request and any wait for a retry or the rate limiter.
+func (c *Client) Do(ctx context.Context, method, endpoint string, body interface{}, response interface{}) error {
+	var bodyJSON []byte
+	if body != nil {
+		var err error
+		if bodyJSON, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		if err := c.limiter.wait(ctx); err != nil {
+			return err
+		}
+
+		retryAfter, err := c.attempt(ctx, method, endpoint, bodyJSON, response)
+		if err == nil || ctx.Err() != nil || attempt >= c.opts.MaxRetries || !retryable(method, err) {
+			return err
+		}
+
+		delay := c.backoff(attempt)
+		if retryAfter > delay {
+			delay = retryAfter
+		}
+		log.Warnf("%s failed, retrying in %s (attempt %d/%d): %v", describe(method, endpoint), delay.Round(time.Millisecond), attempt+1, c.opts.MaxRetries, err)
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// attempt sends a single request. For 429 and 503 responses it also returns
+// the delay the service asked for in Retry-After.
+func (c *Client) attempt(ctx context.Context, method, endpoint string, bodyJSON []byte, response interface{}) (time.Duration, error) {
+	var reqBody io.Reader
+	if bodyJSON != nil {
+		reqBody = bytes.NewReader(bodyJSON)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+endpoint, reqBody)
+	if err != nil {
+		return 0, err
+	}
+	if bodyJSON != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.opts.Auth != nil {
+		c.opts.Auth(req)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return retryAfter(resp), &StatusError{
+			Method:     method,
+			Endpoint:   endpoint,
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Body:       strings.TrimSpace(string(data)),
+		}
+	}
+
+	if response != nil {
+		err := json.NewDecoder(resp.Body).Decode(response)
+		if errors.Is(err, io.EOF) {
+			return 0, nil // Empty body, e.g. 204 No Content
+		}
+		return 0, err
+	}
+
+	return 0, nil
+}
+
+func retryable(method string, err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		if statusErr.StatusCode == http.StatusTooManyRequests {
+			return true // Rejected before it was processed
+		}
+		return idempotent(method) && statusErr.StatusCode >= 500
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true // The request never reached the service
+	}
+	if !idempotent(method) {
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+// idempotent reports whether sending a request with method twice has the same
+// effect as sending it once
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "PUT", "DELETE", "OPTIONS":
+		return true
+	}
+	return false
+}
+
+// backoff returns the delay before retry number attempt+1: exponential, with
+// random jitter between half and all of the delay so clients don't retry in step
+func (c *Client) backoff(attempt int) time.Duration {
+	delay := c.opts.BaseBackoff << attempt
+	if delay <= 0 || delay > c.opts.MaxBackoff {
+		delay = c.opts.MaxBackoff
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func retryAfter(resp *http.Response) time.Duration {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0
+	}
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// limiter spaces out requests to at most rate per second
+type limiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func newLimiter(rate float64) *limiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &limiter{interval: time.Duration(float64(time.Second) / rate)}
+}
+
+func (l *limiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	start := l.next
+	if start.Before(now) {
+		start = now
+	}
+	l.next = start.Add(l.interval)
+	l.mu.Unlock()
+
+	return sleep(ctx, time.Until(start))
+}
+
+// sleep waits for d, returning early with the context's error if ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
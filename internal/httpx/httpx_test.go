@@ -0,0 +1,295 @@
+package httpx
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fastOptions keep retries quick in tests
+func fastOptions(maxRetries int) Options {
+	return Options{MaxRetries: maxRetries, BaseBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+}
+
+// newServer answers each request with the next status in statuses, repeating the last one
+func newServer(t *testing.T, statuses ...int) (*httptest.Server, *int32) {
+	t.Helper()
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(&calls, 1))
+		status := statuses[len(statuses)-1]
+		if n <= len(statuses) {
+			status = statuses[n-1]
+		}
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			w.Write([]byte(`{"ok": true}`))
+		} else {
+			w.Write([]byte(`{"message": "failed"}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		maxRetries int
+		statuses   []int
+		wantCalls  int32
+		wantErr    bool
+	}{
+		{"success", "GET", 0, []int{200}, 1, false},
+		{"get retried on 503", "GET", 0, []int{503, 503, 200}, 3, false},
+		{"get retried on 500", "GET", 0, []int{500, 200}, 2, false},
+		{"get not retried on 404", "GET", 0, []int{404}, 1, true},
+		{"default retries", "GET", 0, []int{500}, 4, true},
+		{"custom retries", "GET", 1, []int{500}, 2, true},
+		{"retries disabled", "GET", -1, []int{500}, 1, true},
+		{"put retried on 502", "PUT", 0, []int{502, 200}, 2, false},
+		{"delete retried on 500", "DELETE", 0, []int{500, 200}, 2, false},
+		{"post retried on 429", "POST", 0, []int{429, 200}, 2, false},
+		{"post not retried on 500", "POST", 0, []int{500}, 1, true},
+		{"post not retried on 502", "POST", 0, []int{502}, 1, true},
+		{"post not retried on 503", "POST", 0, []int{503}, 1, true},
+		{"post not retried on 504", "POST", 0, []int{504}, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, calls := newServer(t, tt.statuses...)
+			client := New(server.URL, fastOptions(tt.maxRetries))
+
+			var response struct {
+				OK bool `json:"ok"`
+			}
+			err := client.Do(context.Background(), tt.method, "/test", map[string]string{"a": "b"}, &response)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !response.OK {
+				t.Error("response wasn't decoded")
+			}
+			if got := atomic.LoadInt32(calls); got != tt.wantCalls {
+				t.Errorf("got %d calls, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRetryOnConnectionFailure(t *testing.T) {
+	// A port nothing listens on
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	for _, method := range []string{"GET", "POST"} {
+		t.Run(method, func(t *testing.T) {
+			client := New("http://"+addr, fastOptions(2))
+
+			var attempts int32
+			client.opts.Auth = func(req *http.Request) { atomic.AddInt32(&attempts, 1) }
+
+			if err := client.Do(context.Background(), method, "/test", nil, nil); err == nil {
+				t.Fatal("expected a connection error")
+			}
+			if got := atomic.LoadInt32(&attempts); got != 3 {
+				t.Errorf("got %d attempts, want 3", got)
+			}
+		})
+	}
+}
+
+func TestRetryOnTimeout(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		method    string
+		wantErr   bool
+		wantCalls int32
+	}{
+		{"GET", false, 2},
+		{"POST", true, 1}, // The request may have been applied before the response was lost
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			atomic.StoreInt32(&calls, 0)
+			opts := fastOptions(1)
+			opts.Timeout = 50 * time.Millisecond
+			client := New(server.URL, opts)
+
+			err := client.Do(context.Background(), tt.method, "/test", nil, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if got := atomic.LoadInt32(&calls); got != tt.wantCalls {
+				t.Errorf("got %d calls, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	start := time.Now()
+	if err := New(server.URL, fastOptions(1)).Get(context.Background(), "/test", nil); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %s, want at least the 1s Retry-After", elapsed)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	client := New("http://localhost", Options{BaseBackoff: 100 * time.Millisecond, MaxBackoff: time.Second})
+
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{0, 100 * time.Millisecond},
+		{1, 200 * time.Millisecond},
+		{2, 400 * time.Millisecond},
+		{3, 800 * time.Millisecond},
+		{4, time.Second},
+		{70, time.Second}, // Shifting overflows
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 50; i++ {
+			delay := client.backoff(tt.attempt)
+			if delay < tt.max/2 || delay > tt.max {
+				t.Fatalf("backoff(%d) = %s, want between %s and %s", tt.attempt, delay, tt.max/2, tt.max)
+			}
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	server, calls := newServer(t, http.StatusOK)
+	opts := fastOptions(0)
+	opts.RateLimit = 20 // One request every 50ms
+	client := New(server.URL, opts)
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := client.Get(context.Background(), "/test", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("5 requests took %s, want at least 200ms at 20 requests per second", elapsed)
+	}
+	if got := atomic.LoadInt32(calls); got != 5 {
+		t.Errorf("got %d calls, want 5", got)
+	}
+}
+
+func TestCancelStopsRetries(t *testing.T) {
+	server, calls := newServer(t, http.StatusServiceUnavailable)
+	client := New(server.URL, Options{MaxRetries: 5, BaseBackoff: time.Hour, MaxBackoff: time.Hour})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := client.Get(ctx, "/test", nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error = %v, want the context's error", err)
+	}
+	if got := atomic.LoadInt32(calls); got != 1 {
+		t.Errorf("got %d calls, want 1", got)
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	long := strings.Repeat("x", 2*maxErrorBody)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			http.Error(w, `{"message": "Series does not exist"}`, http.StatusNotFound)
+		case "/long":
+			http.Error(w, long, http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+	client := New(server.URL, fastOptions(-1))
+
+	err := client.Get(context.Background(), "/missing", nil)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("error = %v, want a StatusError", err)
+	}
+	if statusErr.StatusCode != http.StatusNotFound || statusErr.Method != "GET" || statusErr.Endpoint != "/missing" {
+		t.Errorf("StatusError = %+v", statusErr)
+	}
+	if statusErr.Body != `{"message": "Series does not exist"}` {
+		t.Errorf("Body = %q, want the response body", statusErr.Body)
+	}
+	if !strings.Contains(err.Error(), "Series does not exist") {
+		t.Errorf("error message %q doesn't include the body", err)
+	}
+	if !IsStatus(err, http.StatusNotFound) || IsStatus(err, http.StatusBadRequest) {
+		t.Error("IsStatus doesn't match the status code")
+	}
+
+	err = client.Post(context.Background(), "/long", nil, nil)
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("error = %v, want a StatusError", err)
+	}
+	if len(statusErr.Body) != maxErrorBody {
+		t.Errorf("kept %d bytes of the body, want %d", len(statusErr.Body), maxErrorBody)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Method == "POST" && r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	opts := fastOptions(-1)
+	opts.Auth = func(req *http.Request) { req.Header.Set("X-Api-Key", "secret") }
+	client := New(server.URL+"/", opts)
+
+	var response struct{}
+	if err := client.Post(context.Background(), "/test", map[string]int{"id": 1}, &response); err != nil {
+		t.Errorf("Post: %v", err)
+	}
+}
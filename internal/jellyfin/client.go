@@ -1,18 +1,20 @@
 package jellyfin
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/alex4108/jellycleaner/internal/httpx"
 )
 
 // Client handles communication with the Jellyfin API
 type Client struct {
-	baseURL    string
-	httpClient *http.Client
+	httpClient *httpx.Client
 }
 
 // Item represents a media item in Jellyfin
@@ -21,47 +23,77 @@ type Item struct {
 	Name       string
 	Type       string // "Series" or "Movie"
 	ExternalID string // TVDB/TMDB ID
+	AddedDate  time.Time
+	Tags       []string // Only filled in by GetLibraryItems
+}
+
+// Season represents a season of a series in Jellyfin
+type Season struct {
+	ID     string
+	Name   string
+	Number int
+}
+
+// User represents a Jellyfin user account
+type User struct {
+	ID         string
+	Name       string
+	IsDisabled bool
 }
 
-// NewClient creates a new Jellyfin client
-func NewClient(baseURL, apiKey string) (*Client, error) {
-	// Ensure baseURL doesn't end with a slash
-	baseURL = strings.TrimSuffix(baseURL, "/")
+// UserPlayState holds a single user's playback information for an item
+type UserPlayState struct {
+	User           User
+	Played         bool
+	PlayCount      int
+	LastPlayedDate time.Time // Zero if the user never played the item
+	IsFavorite     bool
+}
+
+// NewClient creates a new Jellyfin client. opts configure retries and rate
+// limiting; authentication is added by the client.
+func NewClient(baseURL, apiKey string, opts httpx.Options) (*Client, error) {
+	opts.Auth = func(req *http.Request) {
+		req.Header.Set("X-Emby-Token", apiKey)
+	}
 
 	return &Client{
-		baseURL: baseURL,
-		apiKey:  apiKey,
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+		httpClient: httpx.New(baseURL, opts),
 	}, nil
 }
 
-// GetLibraryItems returns all items in a specific library
-func (c *Client) GetLibraryItems(libraryName string) ([]Item, error) {
+// GetLibraryItems returns all movies and series in a specific library,
+// including those in nested folders
+func (c *Client) GetLibraryItems(ctx context.Context, libraryName string) ([]Item, error) {
 	// First, get the library ID by name
-	libraryID, err := c.getLibraryIDByName(libraryName)
+	libraryID, err := c.getLibraryIDByName(ctx, libraryName)
 	if err != nil {
 		return nil, err
 	}
 
-	// Now get all items in the library
-	endpoint := fmt.Sprintf("/Items?ParentId=%s", url.QueryEscape(libraryID))
-	var response struct {
-		Items []struct {
-			ID            string   `json:"Id"`
-			Name          string   `json:"Name"`
-			Type          string   `json:"Type"`
-			ProviderIDs   map[string]string `json:"ProviderIds"`
-		} `json:"Items"`
+	// Now get all items in the library, with the metadata that rules use
+	type libraryItem struct {
+		ID          string            `json:"Id"`
+		Name        string            `json:"Name"`
+		Type        string            `json:"Type"`
+		ProviderIDs map[string]string `json:"ProviderIds"`
+		DateCreated time.Time         `json:"DateCreated"`
+		Tags        []string          `json:"Tags"`
 	}
-
-	if err := c.get(endpoint, &response); err != nil {
+	libraryItems, err := getItemPages[libraryItem](ctx, c, "/Items", libraryItemsQuery(libraryID, "Movie,Series"))
+	if err != nil {
 		return nil, err
 	}
 
 	var items []Item
-	for _, item := range response.Items {
+	seen := make(map[string]bool)
+	for _, item := range libraryItems {
+		// Items can shift between pages if the library changes while it is read
+		if seen[item.ID] {
+			continue
+		}
+		seen[item.ID] = true
+
 		externalID := ""
 		if item.Type == "Series" && item.ProviderIDs["Tvdb"] != "" {
 			externalID = item.ProviderIDs["Tvdb"]
@@ -74,109 +106,118 @@ func (c *Client) GetLibraryItems(libraryName string) ([]Item, error) {
 			Name:       item.Name,
 			Type:       item.Type,
 			ExternalID: externalID,
+			AddedDate:  item.DateCreated,
+			Tags:       item.Tags,
 		})
 	}
 
 	return items, nil
 }
 
-// IsWatchedByAllUsers checks if the item has been watched by all users
-func (c *Client) IsWatchedByAllUsers(itemID string) (bool, error) {
-	// First, get all users
-	users, err := c.getUsers()
+// GetLibraryPlayStates returns the playback information of every given user
+// for every item in a library, keyed by item ID. It makes one request per user
+// instead of one per user and item. Items a user can't see count as unplayed.
+func (c *Client) GetLibraryPlayStates(ctx context.Context, libraryName string, users []User) (map[string][]UserPlayState, error) {
+	return c.libraryPlayStates(ctx, libraryName, users, "Movie,Series")
+}
+
+// GetLibrarySeasonPlayStates is GetLibraryPlayStates for the seasons of the
+// series in a library
+func (c *Client) GetLibrarySeasonPlayStates(ctx context.Context, libraryName string, users []User) (map[string][]UserPlayState, error) {
+	return c.libraryPlayStates(ctx, libraryName, users, "Season")
+}
+
+func (c *Client) libraryPlayStates(ctx context.Context, libraryName string, users []User, itemTypes string) (map[string][]UserPlayState, error) {
+	libraryID, err := c.getLibraryIDByName(ctx, libraryName)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	// Check if the item is played for each user
-	for _, user := range users {
-		played, err := c.isItemPlayedByUser(itemID, user.ID)
-		if err != nil {
-			return false, err
+	byUser := make([]map[string]userData, len(users))
+	itemIDs := make(map[string]bool)
+	for i, user := range users {
+		type userItem struct {
+			ID       string   `json:"Id"`
+			UserData userData `json:"UserData"`
 		}
-		if !played {
-			return false, nil // Not watched by at least one user
+		endpoint := fmt.Sprintf("/Users/%s/Items", url.QueryEscape(user.ID))
+		userItems, err := getItemPages[userItem](ctx, c, endpoint, libraryItemsQuery(libraryID, itemTypes))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get play states of %s: %w", user.Name, err)
 		}
-	}
 
-	return true, nil // Watched by all users
-}
-
-// GetItemAddedDate returns the date when the item was added to Jellyfin
-func (c *Client) GetItemAddedDate(itemID string) (time.Time, error) {
-	endpoint := fmt.Sprintf("/Items/%s", url.QueryEscape(itemID))
-	var response struct {
-		DateCreated string `json:"DateCreated"`
+		byUser[i] = make(map[string]userData, len(userItems))
+		for _, item := range userItems {
+			byUser[i][item.ID] = item.UserData
+			itemIDs[item.ID] = true
+		}
 	}
 
-	if err := c.get(endpoint, &response); err != nil {
-		return time.Time{}, err
+	playStates := make(map[string][]UserPlayState, len(itemIDs))
+	for itemID := range itemIDs {
+		states := make([]UserPlayState, 0, len(users))
+		for i, user := range users {
+			states = append(states, newUserPlayState(user, byUser[i][itemID]))
+		}
+		playStates[itemID] = states
 	}
 
-	return time.Parse(time.RFC3339, response.DateCreated)
+	return playStates, nil
 }
 
-// IsInPlaylist checks if an item is in a specific playlist
-func (c *Client) IsInPlaylist(itemID, playlistName string) bool {
-	playlistID, err := c.getPlaylistIDByName(playlistName)
+// GetUserPlayStates returns the playback information of every user for an item
+func (c *Client) GetUserPlayStates(ctx context.Context, itemID string) ([]UserPlayState, error) {
+	users, err := c.GetUsers(ctx)
 	if err != nil {
-		return false
-	}
-
-	endpoint := fmt.Sprintf("/Playlists/%s/Items", url.QueryEscape(playlistID))
-	var response struct {
-		Items []struct {
-			ID string `json:"Id"`
-		} `json:"Items"`
-	}
-
-	if err := c.get(endpoint, &response); err != nil {
-		return false
+		return nil, err
 	}
 
-	for _, item := range response.Items {
-		if item.ID == itemID {
-			return true
+	states := make([]UserPlayState, 0, len(users))
+	for _, user := range users {
+		userData, err := c.getUserData(ctx, itemID, user.ID)
+		if err != nil {
+			return nil, err
 		}
+
+		states = append(states, newUserPlayState(user, userData))
 	}
 
-	return false
+	return states, nil
 }
 
 // AddToPlaylist adds an item to a playlist
-func (c *Client) AddToPlaylist(itemID, playlistName string) error {
+func (c *Client) AddToPlaylist(ctx context.Context, itemID, playlistName string) error {
 	// Get or create the playlist
-	playlistID, err := c.getOrCreatePlaylist(playlistName)
+	playlistID, err := c.getOrCreatePlaylist(ctx, playlistName)
 	if err != nil {
 		return err
 	}
 
 	// Add item to playlist
 	endpoint := fmt.Sprintf("/Playlists/%s/Items?Ids=%s", url.QueryEscape(playlistID), url.QueryEscape(itemID))
-	return c.post(endpoint, nil, nil)
+	return c.httpClient.Post(ctx, endpoint, nil, nil)
 }
 
 // RemoveFromPlaylist removes an item from a playlist
-func (c *Client) RemoveFromPlaylist(itemID, playlistName string) error {
-	playlistID, err := c.getPlaylistIDByName(playlistName)
+func (c *Client) RemoveFromPlaylist(ctx context.Context, itemID, playlistName string) error {
+	playlistID, err := c.getPlaylistIDByName(ctx, playlistName)
 	if err != nil {
 		return err
 	}
 
-	// Get item index in playlist
-	itemIndex, err := c.getItemIndexInPlaylist(itemID, playlistID)
+	// Playlist entries are removed by their entry ID, not the item ID
+	entryID, err := c.getPlaylistEntryID(ctx, itemID, playlistID)
 	if err != nil {
 		return err
 	}
 
-	// Remove item from playlist
-	endpoint := fmt.Sprintf("/Playlists/%s/Items?EntryIds=%d", url.QueryEscape(playlistID), itemIndex)
-	return c.delete(endpoint, nil)
+	endpoint := fmt.Sprintf("/Playlists/%s/Items?EntryIds=%s", url.QueryEscape(playlistID), url.QueryEscape(entryID))
+	return c.httpClient.Delete(ctx, endpoint, nil)
 }
 
 // GetPlaylistItems gets all items in a specific playlist
-func (c *Client) GetPlaylistItems(playlistName string) ([]Item, error) {
-	playlistID, err := c.getPlaylistIDByName(playlistName)
+func (c *Client) GetPlaylistItems(ctx context.Context, playlistName string) ([]Item, error) {
+	playlistID, err := c.getPlaylistIDByName(ctx, playlistName)
 	if err != nil {
 		return nil, err
 	}
@@ -184,14 +225,14 @@ func (c *Client) GetPlaylistItems(playlistName string) ([]Item, error) {
 	endpoint := fmt.Sprintf("/Playlists/%s/Items", url.QueryEscape(playlistID))
 	var response struct {
 		Items []struct {
-			ID            string   `json:"Id"`
-			Name          string   `json:"Name"`
-			Type          string   `json:"Type"`
-			ProviderIDs   map[string]string `json:"ProviderIds"`
+			ID          string            `json:"Id"`
+			Name        string            `json:"Name"`
+			Type        string            `json:"Type"`
+			ProviderIDs map[string]string `json:"ProviderIds"`
 		} `json:"Items"`
 	}
 
-	if err := c.get(endpoint, &response); err != nil {
+	if err := c.httpClient.Get(ctx, endpoint, &response); err != nil {
 		return nil, err
 	}
 
@@ -215,57 +256,155 @@ func (c *Client) GetPlaylistItems(playlistName string) ([]Item, error) {
 	return items, nil
 }
 
-// AddTag adds a tag to an item
-func (c *Client) AddTag(itemID, tag string) error {
-	// Get current tags
-	currentTags, err := c.getTags(itemID)
+// SetExpirationTag replaces all expiration tags of an item with tag, or drops
+// them if tag is empty. The item is only written if its tags change.
+func (c *Client) SetExpirationTag(ctx context.Context, itemID, tag string) error {
+	return c.updateTags(ctx, itemID, func(tags []string) []string {
+		var newTags []string
+		for _, t := range tags {
+			if !strings.HasPrefix(t, "Jellycleaner-Expire-") {
+				newTags = append(newTags, t)
+			}
+		}
+		if tag != "" {
+			newTags = append(newTags, tag)
+		}
+		return newTags
+	})
+}
+
+// GetExpirationTags returns all expiration tags for an item
+func (c *Client) GetExpirationTags(ctx context.Context, itemID string) []string {
+	tags, err := c.getTags(ctx, itemID)
 	if err != nil {
-		return err
+		return nil
 	}
 
-	// Add new tag if not present
-	tags := append(currentTags, tag)
-	return c.updateTags(itemID, tags)
+	var expirationTags []string
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, "Jellycleaner-Expire-") {
+			expirationTags = append(expirationTags, tag)
+		}
+	}
+
+	return expirationTags
 }
 
-// RemoveTag removes a tag from an item
-func (c *Client) RemoveTag(itemID, tag string) error {
-	// Get current tags
-	currentTags, err := c.getTags(itemID)
-	if err != nil {
-		return err
+// GetSeasons returns the seasons of a series
+func (c *Client) GetSeasons(ctx context.Context, seriesID string) ([]Season, error) {
+	endpoint := fmt.Sprintf("/Shows/%s/Seasons", url.QueryEscape(seriesID))
+	var response struct {
+		Items []struct {
+			ID          string `json:"Id"`
+			Name        string `json:"Name"`
+			IndexNumber int    `json:"IndexNumber"`
+		} `json:"Items"`
+	}
+
+	if err := c.httpClient.Get(ctx, endpoint, &response); err != nil {
+		return nil, err
+	}
+
+	seasons := make([]Season, 0, len(response.Items))
+	for _, item := range response.Items {
+		seasons = append(seasons, Season{
+			ID:     item.ID,
+			Name:   item.Name,
+			Number: item.IndexNumber,
+		})
+	}
+
+	return seasons, nil
+}
+
+// GetItemLibraryName returns the name of the library that contains an item
+func (c *Client) GetItemLibraryName(ctx context.Context, itemID string) (string, error) {
+	endpoint := fmt.Sprintf("/Items/%s/Ancestors", url.QueryEscape(itemID))
+	var ancestors []struct {
+		Name string `json:"Name"`
+		Type string `json:"Type"`
 	}
 
-	// Remove tag
-	var newTags []string
-	for _, t := range currentTags {
-		if t != tag {
-			newTags = append(newTags, t)
+	if err := c.httpClient.Get(ctx, endpoint, &ancestors); err != nil {
+		return "", err
+	}
+
+	for _, ancestor := range ancestors {
+		if ancestor.Type == "CollectionFolder" {
+			return ancestor.Name, nil
 		}
 	}
 
-	return c.updateTags(itemID, newTags)
+	return "", fmt.Errorf("library not found for item: %s", itemID)
 }
 
-// GetExpirationTags returns all expiration tags for an item
-func (c *Client) GetExpirationTags(itemID string) []string {
-	tags, err := c.getTags(itemID)
-	if err != nil {
-		return nil
+// GetUsers returns all Jellyfin users, including disabled accounts
+func (c *Client) GetUsers(ctx context.Context) ([]User, error) {
+	endpoint := "/Users"
+	var response []struct {
+		ID     string `json:"Id"`
+		Name   string `json:"Name"`
+		Policy struct {
+			IsDisabled bool `json:"IsDisabled"`
+		} `json:"Policy"`
 	}
 
-	var expirationTags []string
-	for _, tag := range tags {
-		if strings.HasPrefix(tag, "Expires: ") {
-			expirationTags = append(expirationTags, tag)
+	if err := c.httpClient.Get(ctx, endpoint, &response); err != nil {
+		return nil, err
+	}
+
+	users := make([]User, 0, len(response))
+	for _, user := range response {
+		users = append(users, User{
+			ID:         user.ID,
+			Name:       user.Name,
+			IsDisabled: user.Policy.IsDisabled,
+		})
+	}
+
+	return users, nil
+}
+
+// GetUserPlaylistItemIDs returns the IDs of the items in a playlist owned by a
+// user. A user without such a playlist has no items.
+func (c *Client) GetUserPlaylistItemIDs(ctx context.Context, userID, playlistName string) ([]string, error) {
+	endpoint := fmt.Sprintf("/Users/%s/Items?IncludeItemTypes=Playlist&Recursive=true", url.QueryEscape(userID))
+	var playlists struct {
+		Items []struct {
+			ID   string `json:"Id"`
+			Name string `json:"Name"`
+		} `json:"Items"`
+	}
+
+	if err := c.httpClient.Get(ctx, endpoint, &playlists); err != nil {
+		return nil, err
+	}
+
+	var itemIDs []string
+	for _, playlist := range playlists.Items {
+		if !strings.EqualFold(playlist.Name, playlistName) {
+			continue
+		}
+
+		endpoint := fmt.Sprintf("/Playlists/%s/Items?UserId=%s", url.QueryEscape(playlist.ID), url.QueryEscape(userID))
+		var response struct {
+			Items []struct {
+				ID string `json:"Id"`
+			} `json:"Items"`
+		}
+		if err := c.httpClient.Get(ctx, endpoint, &response); err != nil {
+			return nil, err
+		}
+		for _, item := range response.Items {
+			itemIDs = append(itemIDs, item.ID)
 		}
 	}
 
-	return expirationTags
+	return itemIDs, nil
 }
 
 // Helper methods
-func (c *Client) getLibraryIDByName(name string) (string, error) {
+func (c *Client) getLibraryIDByName(ctx context.Context, name string) (string, error) {
 	endpoint := "/Library/MediaFolders"
 	var response struct {
 		Items []struct {
@@ -274,7 +413,7 @@ func (c *Client) getLibraryIDByName(name string) (string, error) {
 		} `json:"Items"`
 	}
 
-	if err := c.get(endpoint, &response); err != nil {
+	if err := c.httpClient.Get(ctx, endpoint, &response); err != nil {
 		return "", err
 	}
 
@@ -287,35 +426,77 @@ func (c *Client) getLibraryIDByName(name string) (string, error) {
 	return "", fmt.Errorf("library not found: %s", name)
 }
 
-func (c *Client) getUsers() ([]struct{ ID string }, error) {
-	endpoint := "/Users"
-	var users []struct {
-		ID string `json:"Id"`
-	}
+type userData struct {
+	Played         bool       `json:"Played"`
+	PlayCount      int        `json:"PlayCount"`
+	LastPlayedDate *time.Time `json:"LastPlayedDate"`
+	IsFavorite     bool       `json:"IsFavorite"`
+}
 
-	if err := c.get(endpoint, &users); err != nil {
-		return nil, err
+func newUserPlayState(user User, data userData) UserPlayState {
+	state := UserPlayState{
+		User:       user,
+		Played:     data.Played,
+		PlayCount:  data.PlayCount,
+		IsFavorite: data.IsFavorite,
 	}
+	if data.LastPlayedDate != nil {
+		state.LastPlayedDate = *data.LastPlayedDate
+	}
+	return state
+}
 
-	return users, nil
+// libraryItemsQuery selects the items of the given types in a library, however
+// deeply they are nested in folders
+func libraryItemsQuery(libraryID, itemTypes string) url.Values {
+	query := url.Values{}
+	query.Set("ParentId", libraryID)
+	query.Set("Recursive", "true")
+	query.Set("IncludeItemTypes", itemTypes)
+	query.Set("Fields", "DateCreated,Tags,ProviderIds,UserData")
+	query.Set("SortBy", "SortName")
+	return query
+}
+
+// itemPageSize is the number of items requested per page
+const itemPageSize = 500
+
+// getItemPages reads every page of an item query
+func getItemPages[T any](ctx context.Context, c *Client, endpoint string, query url.Values) ([]T, error) {
+	var items []T
+	for {
+		query.Set("StartIndex", strconv.Itoa(len(items)))
+		query.Set("Limit", strconv.Itoa(itemPageSize))
+
+		var page struct {
+			Items            []T `json:"Items"`
+			TotalRecordCount int `json:"TotalRecordCount"`
+		}
+		if err := c.httpClient.Get(ctx, endpoint+"?"+query.Encode(), &page); err != nil {
+			return nil, err
+		}
+
+		items = append(items, page.Items...)
+		if len(page.Items) == 0 || len(items) >= page.TotalRecordCount {
+			return items, nil
+		}
+	}
 }
 
-func (c *Client) isItemPlayedByUser(itemID, userID string) (bool, error) {
+func (c *Client) getUserData(ctx context.Context, itemID, userID string) (userData, error) {
 	endpoint := fmt.Sprintf("/Users/%s/Items/%s", url.QueryEscape(userID), url.QueryEscape(itemID))
 	var response struct {
-		UserData struct {
-			Played bool `json:"Played"`
-		} `json:"UserData"`
+		UserData userData `json:"UserData"`
 	}
 
-	if err := c.get(endpoint, &response); err != nil {
-		return false, err
+	if err := c.httpClient.Get(ctx, endpoint, &response); err != nil {
+		return userData{}, err
 	}
 
-	return response.UserData.Played, nil
+	return response.UserData, nil
 }
 
-func (c *Client) getPlaylistIDByName(name string) (string, error) {
+func (c *Client) getPlaylistIDByName(ctx context.Context, name string) (string, error) {
 	endpoint := "/Playlists"
 	var response struct {
 		Items []struct {
@@ -324,7 +505,7 @@ func (c *Client) getPlaylistIDByName(name string) (string, error) {
 		} `json:"Items"`
 	}
 
-	if err := c.get(endpoint, &response); err != nil {
+	if err := c.httpClient.Get(ctx, endpoint, &response); err != nil {
 		return "", err
 	}
 
@@ -337,9 +518,9 @@ func (c *Client) getPlaylistIDByName(name string) (string, error) {
 	return "", fmt.Errorf("playlist not found: %s", name)
 }
 
-func (c *Client) getOrCreatePlaylist(name string) (string, error) {
+func (c *Client) getOrCreatePlaylist(ctx context.Context, name string) (string, error) {
 	// Try to get existing playlist
-	playlistID, err := c.getPlaylistIDByName(name)
+	playlistID, err := c.getPlaylistIDByName(ctx, name)
 	if err == nil {
 		return playlistID, nil
 	}
@@ -347,122 +528,99 @@ func (c *Client) getOrCreatePlaylist(name string) (string, error) {
 	// Create new playlist
 	endpoint := "/Playlists"
 	body := map[string]interface{}{
-		"Name":          name,
-		"MediaType":     "Video",
-		"UserId":        "",
+		"Name":      name,
+		"MediaType": "Video",
+		"UserId":    "",
 	}
 
 	var response struct {
 		ID string `json:"Id"`
 	}
 
-	if err := c.post(endpoint, body, &response); err != nil {
+	if err := c.httpClient.Post(ctx, endpoint, body, &response); err != nil {
 		return "", err
 	}
 
 	return response.ID, nil
 }
 
-func (c *Client) getItemIndexInPlaylist(itemID, playlistID string) (int, error) {
+// getPlaylistEntryID returns the PlaylistItemId of an item's entry in a playlist
+func (c *Client) getPlaylistEntryID(ctx context.Context, itemID, playlistID string) (string, error) {
 	endpoint := fmt.Sprintf("/Playlists/%s/Items", url.QueryEscape(playlistID))
 	var response struct {
 		Items []struct {
-			ID       string `json:"Id"`
+			ID             string `json:"Id"`
 			PlaylistItemID string `json:"PlaylistItemId"`
 		} `json:"Items"`
 	}
 
-	if err := c.get(endpoint, &response); err != nil {
-		return -1, err
+	if err := c.httpClient.Get(ctx, endpoint, &response); err != nil {
+		return "", err
 	}
 
-	for i, item := range response.Items {
+	for _, item := range response.Items {
 		if item.ID == itemID {
-			return i, nil
+			return item.PlaylistItemID, nil
 		}
 	}
 
-	return -1, fmt.Errorf("item not found in playlist")
+	return "", fmt.Errorf("item not found in playlist")
 }
 
-func (c *Client) getTags(itemID string) ([]string, error) {
+func (c *Client) getTags(ctx context.Context, itemID string) ([]string, error) {
 	endpoint := fmt.Sprintf("/Items/%s", url.QueryEscape(itemID))
 	var response struct {
-		TagItems []string `json:"TagItems"`
+		Tags []string `json:"Tags"`
 	}
 
-	if err := c.get(endpoint, &response); err != nil {
+	if err := c.httpClient.Get(ctx, endpoint, &response); err != nil {
 		return nil, err
 	}
 
-	return response.TagItems, nil
+	return response.Tags, nil
 }
 
-func (c *Client) updateTags(itemID string, tags []string) error {
+// updateTags applies update to the tags of an item. Jellyfin replaces the
+// whole item with the body it receives, so the full item is fetched and
+// written back with only its tags changed. Nothing is written if update leaves
+// the tags as they were.
+func (c *Client) updateTags(ctx context.Context, itemID string, update func(tags []string) []string) error {
 	endpoint := fmt.Sprintf("/Items/%s", url.QueryEscape(itemID))
-	body := map[string]interface{}{
-		"Id":       itemID,
-		"TagItems": tags,
-	}
-
-	return c.post(endpoint, body, nil)
-}
+	var item map[string]interface{}
 
-// HTTP helpers
-func (c *Client) get(endpoint string, response interface{}) error {
-	req, err := http.NewRequest("GET", c.baseURL+endpoint, nil)
-	if err != nil {
+	if err := c.httpClient.Get(ctx, endpoint, &item); err != nil {
 		return err
 	}
 
-	return c.doRequest(req, response)
-}
-
-func (c *Client) post(endpoint string, body interface{}, response interface{}) error {
-	var bodyJSON []byte
-	var err error
-	
-	if body != nil {
-		bodyJSON, err = json.Marshal(body)
-		if err != nil {
-			return err
+	var tags []string
+	if raw, ok := item["Tags"].([]interface{}); ok {
+		for _, tag := range raw {
+			if tag, ok := tag.(string); ok {
+				tags = append(tags, tag)
+			}
 		}
 	}
 
-	req, err := http.NewRequest("POST", c.baseURL+endpoint, strings.NewReader(string(bodyJSON)))
-	if err != nil {
-		return err
+	newTags := update(append([]string(nil), tags...))
+	if equalTags(tags, newTags) {
+		return nil
 	}
-
-	req.Header.Set("Content-Type", "application/json")
-	return c.doRequest(req, response)
-}
-
-func (c *Client) delete(endpoint string, response interface{}) error {
-	req, err := http.NewRequest("DELETE", c.baseURL+endpoint, nil)
-	if err != nil {
-		return err
+	if newTags == nil {
+		newTags = []string{}
 	}
+	item["Tags"] = newTags
 
-	return c.doRequest(req, response)
+	return c.httpClient.Post(ctx, endpoint, item, nil)
 }
 
-func (c *Client) doRequest(req *http.Request, response interface{}) error {
-	req.Header.Set("X-Emby-Token", c.apiKey)
-	
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("API request failed with status: %s", resp.Status)
+func equalTags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
 	}
-
-	if response != nil {
-		return json.NewDecoder(resp.Body).Decode(response)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
 	}
-
-	return nil
-}
\ No newline at end of file
+	return true
+}
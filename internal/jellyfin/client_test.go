@@ -0,0 +1,227 @@
+package jellyfin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/alex4108/jellycleaner/internal/httpx"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	client, err := NewClient(server.URL, "key", httpx.Options{MaxRetries: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestRemoveFromPlaylist(t *testing.T) {
+	var removed []string
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/Playlists":
+			json.NewEncoder(w).Encode(map[string]interface{}{"Items": []map[string]string{{"Id": "p1", "Name": "Headed Out"}}})
+		case r.URL.Path == "/Playlists/p1/Items" && r.Method == "GET":
+			json.NewEncoder(w).Encode(map[string]interface{}{"Items": []map[string]string{
+				{"Id": "m1", "PlaylistItemId": "e7"},
+				{"Id": "m2", "PlaylistItemId": "e9"},
+			}})
+		case r.URL.Path == "/Playlists/p1/Items" && r.Method == "DELETE":
+			removed = append(removed, r.URL.Query().Get("EntryIds"))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	if err := client.RemoveFromPlaylist(context.Background(), "m2", "Headed Out"); err != nil {
+		t.Fatalf("RemoveFromPlaylist: %v", err)
+	}
+	if len(removed) != 1 || removed[0] != "e9" {
+		t.Errorf("removed entries %v, want the playlist entry ID e9", removed)
+	}
+
+	if err := client.RemoveFromPlaylist(context.Background(), "m3", "Headed Out"); err == nil {
+		t.Error("removing an item that isn't in the playlist succeeded")
+	}
+}
+
+// newPagedServer returns a client of a server that pages the items of library
+// "Movies" like Jellyfin, under /Items and /Users/{id}/Items. items returns the
+// library's item IDs for a user ("" for /Items) when a page is requested.
+func newPagedServer(t *testing.T, items func(userID string, startIndex int) []string) *Client {
+	t.Helper()
+	return newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		userID := ""
+		switch {
+		case r.URL.Path == "/Library/MediaFolders":
+			json.NewEncoder(w).Encode(map[string]interface{}{"Items": []map[string]string{{"Id": "lib1", "Name": "Movies"}}})
+			return
+		case r.URL.Path == "/Items":
+		case strings.HasPrefix(r.URL.Path, "/Users/") && strings.HasSuffix(r.URL.Path, "/Items"):
+			userID = strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/Users/"), "/Items")
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Query().Get("ParentId") != "lib1" {
+			t.Errorf("query %s doesn't select the library", r.URL.RawQuery)
+		}
+
+		start, _ := strconv.Atoi(r.URL.Query().Get("StartIndex"))
+		limit, _ := strconv.Atoi(r.URL.Query().Get("Limit"))
+		ids := items(userID, start)
+		page := []map[string]interface{}{}
+		for i := start; i < len(ids) && i < start+limit; i++ {
+			page = append(page, map[string]interface{}{
+				"Id":       ids[i],
+				"Name":     "Item " + ids[i],
+				"Type":     "Movie",
+				"UserData": map[string]interface{}{"Played": userID == "u1", "PlayCount": 1},
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"Items": page, "TotalRecordCount": len(ids)})
+	})
+}
+
+func itemIDs(n int) []string {
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = "i" + strconv.Itoa(i+1)
+	}
+	return ids
+}
+
+func TestGetLibraryItemsPages(t *testing.T) {
+	tests := []struct {
+		name  string
+		total int
+	}{
+		{"empty", 0},
+		{"single page", 3},
+		{"exact page", itemPageSize},
+		{"several pages", 2*itemPageSize + 50},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ids := itemIDs(tt.total)
+			client := newPagedServer(t, func(string, int) []string { return ids })
+
+			items, err := client.GetLibraryItems(context.Background(), "Movies")
+			if err != nil {
+				t.Fatalf("GetLibraryItems: %v", err)
+			}
+			if len(items) != tt.total {
+				t.Fatalf("got %d items, want %d", len(items), tt.total)
+			}
+			for i, item := range items {
+				if item.ID != ids[i] {
+					t.Fatalf("item %d has ID %s, want %s", i, item.ID, ids[i])
+				}
+			}
+		})
+	}
+}
+
+func TestGetLibraryItemsShiftingPages(t *testing.T) {
+	ids := itemIDs(itemPageSize + 10)
+	// An item added while the library is read pushes the rest back by one, so
+	// the last item of the first page shows up again on the second
+	shifted := append([]string{"new"}, ids...)
+	client := newPagedServer(t, func(_ string, start int) []string {
+		if start == 0 {
+			return ids
+		}
+		return shifted
+	})
+
+	items, err := client.GetLibraryItems(context.Background(), "Movies")
+	if err != nil {
+		t.Fatalf("GetLibraryItems: %v", err)
+	}
+	if len(items) != len(ids) {
+		t.Fatalf("got %d items, want %d", len(items), len(ids))
+	}
+	seen := make(map[string]bool)
+	for _, item := range items {
+		if seen[item.ID] {
+			t.Fatalf("item %s returned twice", item.ID)
+		}
+		seen[item.ID] = true
+	}
+}
+
+func TestGetLibraryPlayStates(t *testing.T) {
+	all := itemIDs(itemPageSize + 1)
+	client := newPagedServer(t, func(userID string, _ int) []string {
+		if userID == "u2" {
+			return all[:2] // u2 can't see most of the library
+		}
+		return all
+	})
+	users := []User{{ID: "u1", Name: "alice"}, {ID: "u2", Name: "bob"}}
+
+	playStates, err := client.GetLibraryPlayStates(context.Background(), "Movies", users)
+	if err != nil {
+		t.Fatalf("GetLibraryPlayStates: %v", err)
+	}
+	if len(playStates) != len(all) {
+		t.Fatalf("got play states for %d items, want %d", len(playStates), len(all))
+	}
+
+	last := playStates[all[len(all)-1]]
+	if len(last) != 2 {
+		t.Fatalf("got %d play states for the last item, want one per user", len(last))
+	}
+	if !last[0].Played || last[0].User.Name != "alice" {
+		t.Errorf("alice's play state = %+v, want played", last[0])
+	}
+	if last[1].Played || last[1].PlayCount != 0 || last[1].User.Name != "bob" {
+		t.Errorf("bob's play state = %+v, want unplayed for an item he can't see", last[1])
+	}
+	if first := playStates[all[0]]; first[1].PlayCount != 1 {
+		t.Errorf("bob's play state of the first item = %+v, want his play count", first[1])
+	}
+}
+
+func TestGetLibrarySeasonPlayStates(t *testing.T) {
+	var requests int
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/Library/MediaFolders" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"Items": []map[string]string{{"Id": "lib1", "Name": "Shows"}}})
+			return
+		}
+		requests++
+		if got := r.URL.Query().Get("IncludeItemTypes"); got != "Season" {
+			t.Errorf("IncludeItemTypes = %q, want Season", got)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"Items": []map[string]interface{}{
+				{"Id": "s1", "UserData": map[string]interface{}{"Played": true}},
+				{"Id": "s2", "UserData": map[string]interface{}{"Played": false}},
+			},
+			"TotalRecordCount": 2,
+		})
+	})
+	users := []User{{ID: "u1", Name: "alice"}, {ID: "u2", Name: "bob"}}
+
+	playStates, err := client.GetLibrarySeasonPlayStates(context.Background(), "Shows", users)
+	if err != nil {
+		t.Fatalf("GetLibrarySeasonPlayStates: %v", err)
+	}
+	if requests != len(users) {
+		t.Errorf("made %d item requests, want one per user", requests)
+	}
+	if len(playStates) != 2 || !playStates["s1"][1].Played || playStates["s2"][0].Played {
+		t.Errorf("play states = %+v, want s1 played and s2 unplayed by both users", playStates)
+	}
+}
@@ -1,83 +1,131 @@
 package jellyseerr
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"net/http"
-	"net/url"
 	"strconv"
-	"strings"
-	"time"
+
+	"github.com/alex4108/jellycleaner/internal/httpx"
 )
 
 // Client handles communication with the Jellyseerr API
 type Client struct {
-	baseURL    string
-	apiKey     string
-	httpClient *http.Client
+	httpClient *httpx.Client
 }
 
 // MediaRequest represents a media request in Jellyseerr
 type MediaRequest struct {
-	ID         int    `json:"id"`
-	MediaType  string `json:"media"`  // "movie" or "tv"
-	MediaID    int    `json:"mediaId"` // TMDB ID for movies or TVDB ID for series
-	Status     int    `json:"status"`
-	RequestedBy struct {
-		ID   int    `json:"id"`
-		Name string `json:"displayName"`
-	} `json:"requestedBy"`
+	ID        int    `json:"id"`
+	MediaType string `json:"type"` // "movie" or "tv"
+	Status    int    `json:"status"`
+	Media     struct {
+		TMDBID int `json:"tmdbId"`
+		TVDBID int `json:"tvdbId"`
+	} `json:"media"`
+	RequestedBy User `json:"requestedBy"`
 }
 
-// NewClient creates a new Jellyseerr client
-func NewClient(baseURL, apiKey string) (*Client, error) {
-	// Ensure baseURL doesn't end with a slash
-	baseURL = strings.TrimSuffix(baseURL, "/")
+// MediaID returns the TMDB ID for movies or the TVDB ID for series
+func (r MediaRequest) MediaID() int {
+	if r.MediaType == "tv" {
+		return r.Media.TVDBID
+	}
+	return r.Media.TMDBID
+}
 
-	return &Client{
-		baseURL: baseURL,
-		apiKey:  apiKey,
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
-	}, nil
+// User represents a Jellyseerr user
+type User struct {
+	ID               int    `json:"id"`
+	Name             string `json:"displayName"`
+	Username         string `json:"username"`
+	Email            string `json:"email"`
+	JellyfinUsername string `json:"jellyfinUsername"`
+	JellyfinUserID   string `json:"jellyfinUserId"`
 }
 
-// GetAllRequests gets all media requests from Jellyseerr
-func (c *Client) GetAllRequests() ([]MediaRequest, error) {
-	endpoint := "/api/v1/request?take=100&skip=0&filter=all&sort=added"
-	var response struct {
-		Results  []MediaRequest `json:"results"`
-		PageInfo struct {
-			PageSize int `json:"pageSize"`
-			Results  int `json:"results"`
-			Pages    int `json:"pages"`
-			Page     int `json:"page"`
-		} `json:"pageInfo"`
+// RequestIndex looks up requests by the external ID of the requested media
+type RequestIndex struct {
+	requests map[string]MediaRequest
+}
+
+// NewRequestIndex indexes requests by media type and external ID. If the same
+// media was requested more than once, the first request in the list wins.
+func NewRequestIndex(requests []MediaRequest) *RequestIndex {
+	index := &RequestIndex{requests: make(map[string]MediaRequest, len(requests))}
+	for _, request := range requests {
+		key := requestKey(request.MediaType, strconv.Itoa(request.MediaID()))
+		if _, ok := index.requests[key]; !ok {
+			index.requests[key] = request
+		}
 	}
+	return index
+}
 
-	if err := c.get(endpoint, &response); err != nil {
-		return nil, err
+// Find returns the request for a movie (TMDB ID) or tv series (TVDB ID),
+// or nil if the media was never requested
+func (i *RequestIndex) Find(mediaType, externalID string) *MediaRequest {
+	request, ok := i.requests[requestKey(mediaType, externalID)]
+	if !ok {
+		return nil
 	}
+	return &request
+}
+
+func requestKey(mediaType, externalID string) string {
+	if mediaType == "series" {
+		mediaType = "tv"
+	}
+	return mediaType + ":" + externalID
+}
 
-	// If there are multiple pages, fetch them all
-	allRequests := response.Results
-	
-	for page := 2; page <= response.PageInfo.Pages; page++ {
-		endpoint := fmt.Sprintf("/api/v1/request?take=100&skip=%d&filter=all&sort=added", (page-1)*100)
-		
-		if err := c.get(endpoint, &response); err != nil {
+// NewClient creates a new Jellyseerr client. opts configure retries and rate
+// limiting; authentication is added by the client.
+func NewClient(baseURL, apiKey string, opts httpx.Options) (*Client, error) {
+	opts.Auth = func(req *http.Request) {
+		req.Header.Set("X-Api-Key", apiKey)
+	}
+
+	return &Client{
+		httpClient: httpx.New(baseURL, opts),
+	}, nil
+}
+
+// requestPageSize is the number of requests fetched per page
+const requestPageSize = 100
+
+// requestPage is one page of the request list
+type requestPage struct {
+	Results  []MediaRequest `json:"results"`
+	PageInfo struct {
+		PageSize int `json:"pageSize"`
+		Results  int `json:"results"`
+		Pages    int `json:"pages"`
+		Page     int `json:"page"`
+	} `json:"pageInfo"`
+}
+
+// GetAllRequests gets all media requests from Jellyseerr
+func (c *Client) GetAllRequests(ctx context.Context) ([]MediaRequest, error) {
+	var allRequests []MediaRequest
+	for page := 1; ; page++ {
+		endpoint := fmt.Sprintf("/api/v1/request?take=%d&skip=%d&filter=all&sort=added", requestPageSize, (page-1)*requestPageSize)
+
+		// Decode every page into its own struct, so earlier results aren't overwritten
+		var response requestPage
+		if err := c.httpClient.Get(ctx, endpoint, &response); err != nil {
 			return nil, err
 		}
-		
+
 		allRequests = append(allRequests, response.Results...)
+		if page >= response.PageInfo.Pages {
+			return allRequests, nil
+		}
 	}
-
-	return allRequests, nil
 }
 
 // DeleteMovieRequest deletes a movie request by TMDB ID
-func (c *Client) DeleteMovieRequest(tmdbID string) error {
+func (c *Client) DeleteMovieRequest(ctx context.Context, tmdbID string) error {
 	// Convert string to int
 	tmdbIDInt, err := strconv.Atoi(tmdbID)
 	if err != nil {
@@ -85,16 +133,16 @@ func (c *Client) DeleteMovieRequest(tmdbID string) error {
 	}
 
 	// Get all requests
-	requests, err := c.GetAllRequests()
+	requests, err := c.GetAllRequests(ctx)
 	if err != nil {
 		return err
 	}
 
 	// Find the request with matching TMDB ID
 	for _, request := range requests {
-		if request.MediaType == "movie" && request.MediaID == tmdbIDInt {
+		if request.MediaType == "movie" && request.MediaID() == tmdbIDInt {
 			// Delete the request
-			return c.DeleteRequest(request.ID)
+			return c.DeleteRequest(ctx, request.ID)
 		}
 	}
 
@@ -102,7 +150,7 @@ func (c *Client) DeleteMovieRequest(tmdbID string) error {
 }
 
 // DeleteSeriesRequest deletes a series request by TVDB ID
-func (c *Client) DeleteSeriesRequest(tvdbID string) error {
+func (c *Client) DeleteSeriesRequest(ctx context.Context, tvdbID string) error {
 	// Convert string to int
 	tvdbIDInt, err := strconv.Atoi(tvdbID)
 	if err != nil {
@@ -110,16 +158,16 @@ func (c *Client) DeleteSeriesRequest(tvdbID string) error {
 	}
 
 	// Get all requests
-	requests, err := c.GetAllRequests()
+	requests, err := c.GetAllRequests(ctx)
 	if err != nil {
 		return err
 	}
 
 	// Find the request with matching TVDB ID
 	for _, request := range requests {
-		if request.MediaType == "tv" && request.MediaID == tvdbIDInt {
+		if request.MediaType == "tv" && request.MediaID() == tvdbIDInt {
 			// Delete the request
-			return c.DeleteRequest(request.ID)
+			return c.DeleteRequest(ctx, request.ID)
 		}
 	}
 
@@ -127,78 +175,18 @@ func (c *Client) DeleteSeriesRequest(tvdbID string) error {
 }
 
 // DeleteRequest deletes a request by its ID
-func (c *Client) DeleteRequest(requestID int) error {
+func (c *Client) DeleteRequest(ctx context.Context, requestID int) error {
 	endpoint := fmt.Sprintf("/api/v1/request/%d", requestID)
-	return c.delete(endpoint, nil)
+	return c.httpClient.Delete(ctx, endpoint, nil)
 }
 
 // DeleteMediaFromJellyseerr removes media from Jellyseerr when it's deleted from Sonarr/Radarr
-func (c *Client) DeleteMediaFromJellyseerr(mediaType string, externalID string) error {
+func (c *Client) DeleteMediaFromJellyseerr(ctx context.Context, mediaType string, externalID string) error {
 	if mediaType == "movie" {
-		return c.DeleteMovieRequest(externalID)
+		return c.DeleteMovieRequest(ctx, externalID)
 	} else if mediaType == "tv" || mediaType == "series" {
-		return c.DeleteSeriesRequest(externalID)
-	}
-	
-	return fmt.Errorf("unsupported media type: %s", mediaType)
-}
-
-// HTTP helpers
-func (c *Client) get(endpoint string, response interface{}) error {
-	req, err := http.NewRequest("GET", c.baseURL+endpoint, nil)
-	if err != nil {
-		return err
+		return c.DeleteSeriesRequest(ctx, externalID)
 	}
 
-	return c.doRequest(req, response)
-}
-
-func (c *Client) post(endpoint string, body interface{}, response interface{}) error {
-	var bodyJSON []byte
-	var err error
-	
-	if body != nil {
-		bodyJSON, err = json.Marshal(body)
-		if err != nil {
-			return err
-		}
-	}
-
-	req, err := http.NewRequest("POST", c.baseURL+endpoint, strings.NewReader(string(bodyJSON)))
-	if err != nil {
-		return err
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	return c.doRequest(req, response)
-}
-
-func (c *Client) delete(endpoint string, response interface{}) error {
-	req, err := http.NewRequest("DELETE", c.baseURL+endpoint, nil)
-	if err != nil {
-		return err
-	}
-
-	return c.doRequest(req, response)
+	return fmt.Errorf("unsupported media type: %s", mediaType)
 }
-
-func (c *Client) doRequest(req *http.Request, response interface{}) error {
-	// Add API key to all requests
-	req.Header.Set("X-Api-Key", c.apiKey)
-	
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("API request failed with status: %s", resp.Status)
-	}
-
-	if response != nil {
-		return json.NewDecoder(resp.Body).Decode(response)
-	}
-
-	return nil
-}
\ No newline at end of file
@@ -0,0 +1,110 @@
+package jellyseerr
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/alex4108/jellycleaner/internal/httpx"
+)
+
+// newPagedServer serves total requests with IDs 1..total, paged like Jellyseerr
+func newPagedServer(t *testing.T, total int) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/request" {
+			http.NotFound(w, r)
+			return
+		}
+		take, _ := strconv.Atoi(r.URL.Query().Get("take"))
+		skip, _ := strconv.Atoi(r.URL.Query().Get("skip"))
+
+		var page requestPage
+		for id := skip + 1; id <= total && id <= skip+take; id++ {
+			request := MediaRequest{ID: id, MediaType: "movie"}
+			request.Media.TMDBID = 1000 + id
+			page.Results = append(page.Results, request)
+		}
+		page.PageInfo.PageSize = take
+		page.PageInfo.Results = total
+		page.PageInfo.Pages = (total + take - 1) / take
+		page.PageInfo.Page = skip/take + 1
+		json.NewEncoder(w).Encode(page)
+	}))
+}
+
+func TestGetAllRequestsPages(t *testing.T) {
+	tests := []struct {
+		name  string
+		total int
+	}{
+		{"empty", 0},
+		{"single page", 3},
+		{"exact page", requestPageSize},
+		{"several pages", 2*requestPageSize + 50},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newPagedServer(t, tt.total)
+			defer server.Close()
+
+			client, err := NewClient(server.URL, "key", httpx.Options{MaxRetries: -1})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			requests, err := client.GetAllRequests(context.Background())
+			if err != nil {
+				t.Fatalf("GetAllRequests: %v", err)
+			}
+			if len(requests) != tt.total {
+				t.Fatalf("got %d requests, want %d", len(requests), tt.total)
+			}
+			for i, request := range requests {
+				if request.ID != i+1 {
+					t.Fatalf("request %d has ID %d, want %d", i, request.ID, i+1)
+				}
+				if request.MediaID() != 1000+i+1 {
+					t.Fatalf("request %d has media ID %d, want %d", i, request.MediaID(), 1000+i+1)
+				}
+			}
+		})
+	}
+}
+
+func TestRequestIndexFind(t *testing.T) {
+	movie := MediaRequest{ID: 1, MediaType: "movie"}
+	movie.Media.TMDBID = 603
+	series := MediaRequest{ID: 2, MediaType: "tv"}
+	series.Media.TVDBID = 81189
+	duplicate := MediaRequest{ID: 3, MediaType: "movie"}
+	duplicate.Media.TMDBID = 603
+
+	index := NewRequestIndex([]MediaRequest{movie, series, duplicate})
+
+	tests := []struct {
+		mediaType  string
+		externalID string
+		wantID     int
+	}{
+		{"movie", "603", 1},
+		{"tv", "81189", 2},
+		{"series", "81189", 2},
+		{"tv", "603", 0},
+		{"movie", "1", 0},
+	}
+
+	for _, tt := range tests {
+		request := index.Find(tt.mediaType, tt.externalID)
+		switch {
+		case tt.wantID == 0 && request != nil:
+			t.Errorf("Find(%s, %s) = request %d, want none", tt.mediaType, tt.externalID, request.ID)
+		case tt.wantID != 0 && (request == nil || request.ID != tt.wantID):
+			t.Errorf("Find(%s, %s) = %v, want request %d", tt.mediaType, tt.externalID, request, tt.wantID)
+		}
+	}
+}
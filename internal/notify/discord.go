@@ -0,0 +1,33 @@
+package notify
+
+import (
+	"context"
+
+	"github.com/alex4108/jellycleaner/internal/httpx"
+)
+
+// Discord embed descriptions are limited to 4096 characters
+const discordMaxDescription = 4096
+
+// Discord posts digests to a Discord webhook
+type Discord struct {
+	httpClient *httpx.Client
+}
+
+// Name returns the provider name
+func (d *Discord) Name() string {
+	return "discord"
+}
+
+// Notify posts the digest as an embed
+func (d *Discord) Notify(ctx context.Context, digest *Digest) error {
+	body := map[string]interface{}{
+		"embeds": []map[string]interface{}{
+			{
+				"title":       digest.Title(),
+				"description": truncate(digest.Text(), discordMaxDescription),
+			},
+		},
+	}
+	return d.httpClient.Post(ctx, "", body, nil)
+}
@@ -0,0 +1,117 @@
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alex4108/jellycleaner/config"
+)
+
+// Email sends digests over SMTP
+type Email struct {
+	host     string
+	port     int
+	username string
+	password string
+	from     string
+	to       []string
+}
+
+// NewEmail creates an SMTP notifier
+func NewEmail(cfg config.EmailConfig, password string) *Email {
+	return &Email{
+		host:     cfg.Host,
+		port:     cfg.Port,
+		username: cfg.Username,
+		password: password,
+		from:     cfg.From,
+		to:       cfg.To,
+	}
+}
+
+// Name returns the provider name
+func (e *Email) Name() string {
+	return "email"
+}
+
+// Notify sends the digest to every configured recipient
+func (e *Email) Notify(ctx context.Context, digest *Digest) error {
+	return e.Send(ctx, e.to, digest.Title(), digest.Text())
+}
+
+// Send sends a plain text message. Port 465 uses implicit TLS; other ports
+// use STARTTLS when the server offers it.
+func (e *Email) Send(ctx context.Context, to []string, subject, body string) error {
+	addr := net.JoinHostPort(e.host, strconv.Itoa(e.port))
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if e.port == 465 {
+		conn = tls.Client(conn, &tls.Config{ServerName: e.host})
+	}
+
+	client, err := smtp.NewClient(conn, e.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if e.port != 465 {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(&tls.Config{ServerName: e.host}); err != nil {
+				return err
+			}
+		}
+	}
+	if e.username != "" {
+		if err := client.Auth(smtp.PlainAuth("", e.username, e.password, e.host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(e.from); err != nil {
+		return err
+	}
+	for _, recipient := range to {
+		if err := client.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(e.message(to, subject, body)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func (e *Email) message(to []string, subject, body string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", e.from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject)) // Titles may not be ASCII
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return []byte(b.String())
+}
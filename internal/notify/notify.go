@@ -0,0 +1,165 @@
+// Package notify sends a digest of each run to chat services, webhooks and email
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/alex4108/jellycleaner/config"
+	"github.com/alex4108/jellycleaner/internal/httpx"
+)
+
+// Item is a single title in a digest
+type Item struct {
+	Name           string `json:"name"`
+	Type           string `json:"type"`
+	Library        string `json:"library,omitempty"`
+	Reason         string `json:"reason,omitempty"`
+	Action         string `json:"action,omitempty"`          // What was done to a removed item, e.g. "delete"
+	Seasons        []int  `json:"seasons,omitempty"`         // Seasons removed by season cleanup
+	ExpirationDate string `json:"expiration_date,omitempty"` // When a marked item leaves, as YYYY-MM-DD
+
+	// The Jellyseerr user who requested the item, if any
+	RequestedBy      string `json:"requested_by,omitempty"`
+	RequestedByEmail string `json:"requested_by_email,omitempty"`
+}
+
+// Digest summarises what a single run did
+type Digest struct {
+	Marked  []Item `json:"marked"`  // Items newly marked for deletion
+	Removed []Item `json:"removed"` // Items whose expiration action was carried out
+}
+
+// Empty reports whether there is nothing to notify about
+func (d *Digest) Empty() bool {
+	return len(d.Marked) == 0 && len(d.Removed) == 0
+}
+
+// Title returns a one-line summary of the digest
+func (d *Digest) Title() string {
+	var parts []string
+	if len(d.Marked) > 0 {
+		parts = append(parts, fmt.Sprintf("%d headed out", len(d.Marked)))
+	}
+	if len(d.Removed) > 0 {
+		parts = append(parts, fmt.Sprintf("%d removed", len(d.Removed)))
+	}
+	return "Jellycleaner: " + strings.Join(parts, ", ")
+}
+
+// Text renders the digest as plain text, one line per item
+func (d *Digest) Text() string {
+	var b strings.Builder
+	if len(d.Marked) > 0 {
+		fmt.Fprintf(&b, "Headed out (%d):\n", len(d.Marked))
+		for _, item := range d.Marked {
+			fmt.Fprintf(&b, "- %s%s leaves on %s", item.Name, libraryLabel(item), item.ExpirationDate)
+			if item.Reason != "" {
+				fmt.Fprintf(&b, ": %s", item.Reason)
+			}
+			b.WriteString("\n")
+		}
+	}
+	if len(d.Removed) > 0 {
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "Removed (%d):\n", len(d.Removed))
+		for _, item := range d.Removed {
+			fmt.Fprintf(&b, "- %s%s: %s", item.Name, libraryLabel(item), actionLabel(item))
+			if item.Reason != "" {
+				fmt.Fprintf(&b, " (%s)", item.Reason)
+			}
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+func libraryLabel(item Item) string {
+	if item.Library == "" {
+		return ""
+	}
+	return " [" + item.Library + "]"
+}
+
+func actionLabel(item Item) string {
+	switch item.Action {
+	case "delete":
+		return "deleted"
+	case "delete_season":
+		seasons := make([]string, len(item.Seasons))
+		for i, season := range item.Seasons {
+			seasons[i] = fmt.Sprint(season)
+		}
+		return "deleted season(s) " + strings.Join(seasons, ", ")
+	case "unmonitor":
+		return "unmonitored"
+	case "unmonitor_and_delete_files":
+		return "unmonitored, files deleted"
+	case "downgrade":
+		return "downgraded"
+	}
+	return item.Action
+}
+
+// Notifier delivers a digest to a single destination
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, d *Digest) error
+}
+
+// New creates a notifier for every configured provider. The SMTP password
+// is read from the SMTP_PASSWORD environment variable.
+func New(cfg config.NotificationsConfig) []Notifier {
+	var notifiers []Notifier
+	if cfg.Discord != nil {
+		notifiers = append(notifiers, &Discord{httpClient: newWebhookClient(cfg.Discord.URL, nil)})
+	}
+	if cfg.Slack != nil {
+		notifiers = append(notifiers, &Slack{httpClient: newWebhookClient(cfg.Slack.URL, nil)})
+	}
+	if cfg.Webhook != nil {
+		notifiers = append(notifiers, &Webhook{httpClient: newWebhookClient(cfg.Webhook.URL, cfg.Webhook.Headers)})
+	}
+	if cfg.Email != nil && len(cfg.Email.To) > 0 {
+		notifiers = append(notifiers, NewEmail(*cfg.Email, os.Getenv("SMTP_PASSWORD")))
+	}
+	if cfg.Email != nil && cfg.Requesters.Enabled {
+		notifiers = append(notifiers, &Requesters{
+			email:            NewEmail(*cfg.Email, os.Getenv("SMTP_PASSWORD")),
+			keepInstructions: cfg.Requesters.KeepInstructions,
+		})
+	}
+	return notifiers
+}
+
+// newWebhookClient posts to a webhook URL with the shared retries, adding
+// headers to every request. Requests are sent to the URL itself, so that
+// tokens in its path don't end up in error messages.
+func newWebhookClient(webhookURL string, headers map[string]string) *httpx.Client {
+	return httpx.New(webhookURL, httpx.Options{
+		Auth: func(req *http.Request) {
+			for key, value := range headers {
+				req.Header.Set(key, value)
+			}
+		},
+	})
+}
+
+// truncate shortens s to at most max bytes, for services that limit message length
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	const suffix = "\n…"
+	cut := max - len(suffix)
+	// Don't cut a UTF-8 sequence in half
+	for cut > 0 && s[cut]&0xC0 == 0x80 {
+		cut--
+	}
+	return s[:cut] + suffix
+}
@@ -0,0 +1,125 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/alex4108/jellycleaner/config"
+)
+
+func testDigest() *Digest {
+	return &Digest{
+		Marked:  []Item{{Name: "Old Movie", Library: "Movies", Reason: "Exceeds maximum age", ExpirationDate: "2026-11-01"}},
+		Removed: []Item{{Name: "Old Show", Library: "TV Shows", Action: "delete"}},
+	}
+}
+
+func TestWebhookNotifiers(t *testing.T) {
+	var body map[string]interface{}
+	var header string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		cfg        config.NotificationsConfig
+		field      string
+		wantHeader string
+	}{
+		{"discord", config.NotificationsConfig{Discord: &config.WebhookConfig{URL: server.URL}}, "embeds", ""},
+		{"slack", config.NotificationsConfig{Slack: &config.WebhookConfig{URL: server.URL}}, "text", ""},
+		{
+			"webhook",
+			config.NotificationsConfig{Webhook: &config.WebhookConfig{URL: server.URL, Headers: map[string]string{"Authorization": "Bearer token"}}},
+			"marked",
+			"Bearer token",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, header = nil, ""
+			notifiers := New(tt.cfg)
+			if len(notifiers) != 1 || notifiers[0].Name() != tt.name {
+				t.Fatalf("New created %d notifiers, want a single %s notifier", len(notifiers), tt.name)
+			}
+
+			if err := notifiers[0].Notify(context.Background(), testDigest()); err != nil {
+				t.Fatalf("Notify: %v", err)
+			}
+			if _, ok := body[tt.field]; !ok {
+				t.Errorf("posted body %v has no %q field", body, tt.field)
+			}
+			if header != tt.wantHeader {
+				t.Errorf("Authorization header = %q, want %q", header, tt.wantHeader)
+			}
+		})
+	}
+}
+
+func TestWebhookErrors(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		http.Error(w, `{"message": "Invalid Webhook Token"}`, http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	notifiers := New(config.NotificationsConfig{Discord: &config.WebhookConfig{URL: server.URL + "/api/webhooks/1/secret"}})
+	err := notifiers[0].Notify(context.Background(), testDigest())
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Errorf("got %d calls, want the 429 to be retried once", got)
+	}
+	if !strings.Contains(err.Error(), "Invalid Webhook Token") {
+		t.Errorf("error %q doesn't include the response body", err)
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Errorf("error %q includes the webhook token", err)
+	}
+}
+
+func TestNewEmailNotifiers(t *testing.T) {
+	email := &config.EmailConfig{Host: "smtp.example.com", From: "jellycleaner@example.com"}
+
+	tests := []struct {
+		name       string
+		to         []string
+		requesters bool
+		want       []string
+	}{
+		{"digest", []string{"admin@example.com"}, false, []string{"email"}},
+		{"requesters only", nil, true, []string{"requesters"}},
+		{"both", []string{"admin@example.com"}, true, []string{"email", "requesters"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := *email
+			cfg.To = tt.to
+			notifiers := New(config.NotificationsConfig{Email: &cfg, Requesters: config.RequesterNotifications{Enabled: tt.requesters}})
+
+			var names []string
+			for _, notifier := range notifiers {
+				names = append(names, notifier.Name())
+			}
+			if strings.Join(names, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("notifiers = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
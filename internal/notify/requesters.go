@@ -0,0 +1,78 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Requesters emails each Jellyseerr requester about their own titles that were
+// marked, instead of sending the whole digest to a fixed address
+type Requesters struct {
+	email            *Email
+	keepInstructions string
+}
+
+// Name returns the provider name
+func (r *Requesters) Name() string {
+	return "requesters"
+}
+
+// Notify sends one email per requester listing their marked titles. Items
+// without a requester email are skipped.
+func (r *Requesters) Notify(ctx context.Context, digest *Digest) error {
+	byEmail := make(map[string][]Item)
+	for _, item := range digest.Marked {
+		if item.RequestedByEmail == "" {
+			continue
+		}
+		byEmail[item.RequestedByEmail] = append(byEmail[item.RequestedByEmail], item)
+	}
+
+	emails := make([]string, 0, len(byEmail))
+	for email := range byEmail {
+		emails = append(emails, email)
+	}
+	sort.Strings(emails)
+
+	var failed []string
+	for _, email := range emails {
+		items := byEmail[email]
+		if err := r.email.Send(ctx, []string{email}, requesterSubject(items), r.requesterBody(items)); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", email, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to notify %d requester(s): %s", len(failed), strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+func requesterSubject(items []Item) string {
+	if len(items) == 1 {
+		return fmt.Sprintf("%s is leaving on %s", items[0].Name, items[0].ExpirationDate)
+	}
+	return fmt.Sprintf("%d titles you requested are leaving soon", len(items))
+}
+
+func (r *Requesters) requesterBody(items []Item) string {
+	var b strings.Builder
+	if name := items[0].RequestedBy; name != "" {
+		fmt.Fprintf(&b, "Hi %s,\n\n", name)
+	} else {
+		b.WriteString("Hi,\n\n")
+	}
+
+	b.WriteString("The following titles you requested are headed out and will be removed from the server:\n\n")
+	for _, item := range items {
+		fmt.Fprintf(&b, "- %s, leaves on %s\n", item.Name, item.ExpirationDate)
+	}
+
+	instructions := r.keepInstructions
+	if instructions == "" {
+		instructions = "If you would like to keep any of them, let the server admin know before that date."
+	}
+	fmt.Fprintf(&b, "\n%s\n", instructions)
+	return b.String()
+}
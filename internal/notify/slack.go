@@ -0,0 +1,25 @@
+package notify
+
+import (
+	"context"
+
+	"github.com/alex4108/jellycleaner/internal/httpx"
+)
+
+// Slack posts digests to a Slack incoming webhook
+type Slack struct {
+	httpClient *httpx.Client
+}
+
+// Name returns the provider name
+func (s *Slack) Name() string {
+	return "slack"
+}
+
+// Notify posts the digest as a plain text message
+func (s *Slack) Notify(ctx context.Context, digest *Digest) error {
+	body := map[string]string{
+		"text": "*" + digest.Title() + "*\n" + digest.Text(),
+	}
+	return s.httpClient.Post(ctx, "", body, nil)
+}
@@ -0,0 +1,31 @@
+package notify
+
+import (
+	"context"
+
+	"github.com/alex4108/jellycleaner/internal/httpx"
+)
+
+// Webhook posts digests as JSON to an arbitrary URL
+type Webhook struct {
+	httpClient *httpx.Client
+}
+
+// Name returns the provider name
+func (w *Webhook) Name() string {
+	return "webhook"
+}
+
+// Notify posts the digest with its title and text alongside the raw items
+func (w *Webhook) Notify(ctx context.Context, digest *Digest) error {
+	body := struct {
+		Title string `json:"title"`
+		Text  string `json:"text"`
+		*Digest
+	}{
+		Title:  digest.Title(),
+		Text:   digest.Text(),
+		Digest: digest,
+	}
+	return w.httpClient.Post(ctx, "", body, nil)
+}
@@ -0,0 +1,79 @@
+package plan
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+)
+
+// Action describes what jellycleaner would do to an item
+type Action string
+
+const (
+	ActionMark   Action = "mark"
+	ActionUnmark Action = "unmark"
+	ActionDelete Action = "delete"
+
+	// ActionDeleteSeason removes the files of a single season, keeping the series
+	ActionDeleteSeason Action = "delete_season"
+
+	// ActionUnmonitor stops Sonarr/Radarr from grabbing or upgrading a title
+	ActionUnmonitor               Action = "unmonitor"
+	ActionUnmonitorAndDeleteFiles Action = "unmonitor_and_delete_files"
+
+	// ActionDowngrade switches a title to a smaller quality profile
+	ActionDowngrade Action = "downgrade"
+
+	// ActionPurge permanently deletes a folder from the recycle bin
+	ActionPurge Action = "purge"
+)
+
+// Entry represents a single planned action
+type Entry struct {
+	ItemID         string `json:"item_id"`
+	Item           string `json:"item"`
+	Type           string `json:"type"`
+	Library        string `json:"library,omitempty"`
+	Rule           string `json:"rule,omitempty"`
+	Reason         string `json:"reason,omitempty"`
+	Action         Action `json:"action"`
+	ExpirationDate string `json:"expiration_date,omitempty"`
+}
+
+// Plan collects the actions of a dry run instead of executing them
+type Plan struct {
+	Entries []Entry `json:"entries"`
+}
+
+// New creates an empty plan
+func New() *Plan {
+	return &Plan{Entries: []Entry{}}
+}
+
+// Add records a planned action
+func (p *Plan) Add(entry Entry) {
+	p.Entries = append(p.Entries, entry)
+}
+
+// Write encodes the plan as JSON, ordered so that two plans can be diffed
+func (p *Plan) Write(w io.Writer) error {
+	entries := make([]Entry, len(p.Entries))
+	copy(entries, p.Entries)
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Action != entries[j].Action {
+			return entries[i].Action < entries[j].Action
+		}
+		if entries[i].Library != entries[j].Library {
+			return entries[i].Library < entries[j].Library
+		}
+		if entries[i].Item != entries[j].Item {
+			return entries[i].Item < entries[j].Item
+		}
+		return entries[i].ItemID < entries[j].ItemID
+	})
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(Plan{Entries: entries})
+}
@@ -1,46 +1,79 @@
 package radarr
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"net/http"
 	"net/url"
 	"strconv"
-	"strings"
-	"time"
+
+	"github.com/alex4108/jellycleaner/internal/httpx"
 )
 
 // Client handles communication with the Radarr API
 type Client struct {
-	baseURL    string
-	apiKey     string
-	httpClient *http.Client
+	httpClient *httpx.Client
 }
 
 // Movie represents a movie in Radarr
 type Movie struct {
-	ID       int    `json:"id"`
-	Title    string `json:"title"`
-	TMDBID   int    `json:"tmdbId"`
-	FilePath string `json:"path"`
+	ID                  int    `json:"id"`
+	Title               string `json:"title"`
+	Year                int    `json:"year"`
+	TMDBID              int    `json:"tmdbId"`
+	FilePath            string `json:"path"`
+	RootFolderPath      string `json:"rootFolderPath"`
+	QualityProfileID    int    `json:"qualityProfileId"`
+	Tags                []int  `json:"tags"`
+	Monitored           bool   `json:"monitored"`
+	MinimumAvailability string `json:"minimumAvailability"`
+	SizeOnDisk          int64  `json:"sizeOnDisk"`
+}
+
+// MovieFile represents a movie file on disk
+type MovieFile struct {
+	ID      int    `json:"id"`
+	MovieID int    `json:"movieId"`
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+}
+
+// QualityProfile represents a quality profile in Radarr
+type QualityProfile struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// Tag represents a tag in Radarr
+type Tag struct {
+	ID    int    `json:"id"`
+	Label string `json:"label"`
+}
+
+// DiskSpace represents a disk as reported by Radarr
+type DiskSpace struct {
+	Path       string `json:"path"`
+	Label      string `json:"label"`
+	FreeSpace  int64  `json:"freeSpace"`
+	TotalSpace int64  `json:"totalSpace"`
 }
 
-// NewClient creates a new Radarr client
-func NewClient(baseURL, apiKey string) (*Client, error) {
-	// Ensure baseURL doesn't end with a slash
-	baseURL = strings.TrimSuffix(baseURL, "/")
+// NewClient creates a new Radarr client. opts configure retries and rate
+// limiting; authentication is added by the client.
+func NewClient(baseURL, apiKey string, opts httpx.Options) (*Client, error) {
+	opts.Auth = func(req *http.Request) {
+		q := req.URL.Query()
+		q.Set("apikey", apiKey)
+		req.URL.RawQuery = q.Encode()
+	}
 
 	return &Client{
-		baseURL: baseURL,
-		apiKey:  apiKey,
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+		httpClient: httpx.New(baseURL, opts),
 	}, nil
 }
 
 // GetMovieByTMDBID gets a movie by its TMDB ID
-func (c *Client) GetMovieByTMDBID(tmdbID string) (*Movie, error) {
+func (c *Client) GetMovieByTMDBID(ctx context.Context, tmdbID string) (*Movie, error) {
 	// Convert string to int
 	tmdbIDInt, err := strconv.Atoi(tmdbID)
 	if err != nil {
@@ -48,7 +81,7 @@ func (c *Client) GetMovieByTMDBID(tmdbID string) (*Movie, error) {
 	}
 
 	// Get all movies
-	movies, err := c.GetAllMovies()
+	movies, err := c.GetAllMovies(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -64,96 +97,184 @@ func (c *Client) GetMovieByTMDBID(tmdbID string) (*Movie, error) {
 }
 
 // GetAllMovies gets all movies from Radarr
-func (c *Client) GetAllMovies() ([]Movie, error) {
+func (c *Client) GetAllMovies(ctx context.Context) ([]Movie, error) {
 	endpoint := "/api/v3/movie"
 	var movies []Movie
 
-	if err := c.get(endpoint, &movies); err != nil {
+	if err := c.httpClient.Get(ctx, endpoint, &movies); err != nil {
 		return nil, err
 	}
 
 	return movies, nil
 }
 
-// DeleteMovie deletes a movie from Radarr
-func (c *Client) DeleteMovie(tmdbID string) error {
-	// First get the Radarr movie ID from TMDB ID
-	movie, err := c.GetMovieByTMDBID(tmdbID)
-	if err != nil {
-		return err
-	}
+// DeleteOptions controls what happens to a movie's files when it is deleted
+type DeleteOptions struct {
+	DeleteFiles            bool // Delete the movie files from disk
+	AddImportListExclusion bool // Prevent import lists from adding it again
+}
+
+// DeleteMovieByID deletes a movie from Radarr by its Radarr ID
+func (c *Client) DeleteMovieByID(ctx context.Context, movieID int, opts DeleteOptions) error {
+	endpoint := fmt.Sprintf("/api/v3/movie/%d", movieID)
 
-	// Delete the movie
-	endpoint := fmt.Sprintf("/api/v3/movie/%d", movie.ID)
-	
 	// Add query parameters for deletion options
 	queryParams := url.Values{}
-	queryParams.Add("deleteFiles", "true")  // Delete the movie files
-	queryParams.Add("addImportExclusion", "false") // Don't add to import exclusions
-	
+	queryParams.Add("deleteFiles", strconv.FormatBool(opts.DeleteFiles))
+	queryParams.Add("addImportExclusion", strconv.FormatBool(opts.AddImportListExclusion))
+
 	endpoint = endpoint + "?" + queryParams.Encode()
-	
-	return c.delete(endpoint, nil)
+
+	return c.httpClient.Delete(ctx, endpoint, nil)
 }
 
-// HTTP helpers
-func (c *Client) get(endpoint string, response interface{}) error {
-	req, err := http.NewRequest("GET", c.baseURL+endpoint, nil)
-	if err != nil {
-		return err
+// AddMovieOptions controls how a movie is added to Radarr
+type AddMovieOptions struct {
+	QualityProfileID    int
+	RootFolderPath      string
+	Monitored           bool
+	MinimumAvailability string
+	Tags                []int
+	Search              bool // Search for the movie once added
+}
+
+// AddMovie looks up a movie by TMDB ID and adds it to Radarr
+func (c *Client) AddMovie(ctx context.Context, tmdbID string, opts AddMovieOptions) (*Movie, error) {
+	endpoint := "/api/v3/movie/lookup/tmdb?tmdbId=" + url.QueryEscape(tmdbID)
+	var body map[string]interface{}
+
+	if err := c.httpClient.Get(ctx, endpoint, &body); err != nil {
+		return nil, err
+	}
+	if len(body) == 0 {
+		return nil, fmt.Errorf("movie with TMDB ID %s not found in lookup", tmdbID)
 	}
 
-	return c.doRequest(req, response)
+	// Start from the lookup result so that metadata is included
+	body["qualityProfileId"] = opts.QualityProfileID
+	body["rootFolderPath"] = opts.RootFolderPath
+	body["monitored"] = opts.Monitored
+	if opts.MinimumAvailability != "" {
+		body["minimumAvailability"] = opts.MinimumAvailability
+	}
+	if opts.Tags != nil {
+		body["tags"] = opts.Tags
+	} else {
+		body["tags"] = []int{}
+	}
+	body["addOptions"] = map[string]interface{}{
+		"searchForMovie": opts.Search,
+	}
+
+	var movie Movie
+	if err := c.httpClient.Post(ctx, "/api/v3/movie", body, &movie); err != nil {
+		return nil, err
+	}
+
+	return &movie, nil
 }
 
-func (c *Client) post(endpoint string, body interface{}, response interface{}) error {
-	var bodyJSON []byte
-	var err error
-	
-	if body != nil {
-		bodyJSON, err = json.Marshal(body)
-		if err != nil {
-			return err
-		}
+// SearchMovie triggers a search for a movie
+func (c *Client) SearchMovie(ctx context.Context, movieID int) error {
+	body := map[string]interface{}{
+		"name":     "MoviesSearch",
+		"movieIds": []int{movieID},
 	}
+	return c.httpClient.Post(ctx, "/api/v3/command", body, nil)
+}
 
-	req, err := http.NewRequest("POST", c.baseURL+endpoint, strings.NewReader(string(bodyJSON)))
-	if err != nil {
-		return err
+// RescanMovie triggers a rescan of a movie's folder, picking up files that were added to it
+func (c *Client) RescanMovie(ctx context.Context, movieID int) error {
+	body := map[string]interface{}{
+		"name":    "RescanMovie",
+		"movieId": movieID,
+	}
+	return c.httpClient.Post(ctx, "/api/v3/command", body, nil)
+}
+
+// GetMovieFiles gets all files of a movie
+func (c *Client) GetMovieFiles(ctx context.Context, movieID int) ([]MovieFile, error) {
+	endpoint := fmt.Sprintf("/api/v3/moviefile?movieId=%d", movieID)
+	var files []MovieFile
+
+	if err := c.httpClient.Get(ctx, endpoint, &files); err != nil {
+		return nil, err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	return c.doRequest(req, response)
+	return files, nil
 }
 
-func (c *Client) delete(endpoint string, response interface{}) error {
-	req, err := http.NewRequest("DELETE", c.baseURL+endpoint, nil)
-	if err != nil {
+// DeleteMovieFile deletes a movie file from disk
+func (c *Client) DeleteMovieFile(ctx context.Context, movieFileID int) error {
+	endpoint := fmt.Sprintf("/api/v3/moviefile/%d", movieFileID)
+	return c.httpClient.Delete(ctx, endpoint, nil)
+}
+
+// SetMovieMonitored changes whether Radarr monitors a movie
+func (c *Client) SetMovieMonitored(ctx context.Context, movieID int, monitored bool) error {
+	return c.updateMovie(ctx, movieID, func(movie map[string]interface{}) error {
+		movie["monitored"] = monitored
+		return nil
+	})
+}
+
+// updateMovie fetches the full movie resource, applies update and writes it back.
+// The raw resource is used so that fields this client doesn't model are preserved.
+func (c *Client) updateMovie(ctx context.Context, movieID int, update func(movie map[string]interface{}) error) error {
+	endpoint := fmt.Sprintf("/api/v3/movie/%d", movieID)
+	var movie map[string]interface{}
+
+	if err := c.httpClient.Get(ctx, endpoint, &movie); err != nil {
+		return err
+	}
+
+	if err := update(movie); err != nil {
 		return err
 	}
 
-	return c.doRequest(req, response)
+	return c.httpClient.Put(ctx, endpoint, movie, nil)
 }
 
-func (c *Client) doRequest(req *http.Request, response interface{}) error {
-	// Add API key to all requests
-	q := req.URL.Query()
-	q.Add("apikey", c.apiKey)
-	req.URL.RawQuery = q.Encode()
-	
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return err
+// GetQualityProfiles gets all quality profiles from Radarr
+func (c *Client) GetQualityProfiles(ctx context.Context) ([]QualityProfile, error) {
+	endpoint := "/api/v3/qualityprofile"
+	var profiles []QualityProfile
+
+	if err := c.httpClient.Get(ctx, endpoint, &profiles); err != nil {
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("API request failed with status: %s", resp.Status)
+	return profiles, nil
+}
+
+// SetMovieQualityProfile switches a movie to another quality profile
+func (c *Client) SetMovieQualityProfile(ctx context.Context, movieID, profileID int) error {
+	return c.updateMovie(ctx, movieID, func(movie map[string]interface{}) error {
+		movie["qualityProfileId"] = profileID
+		return nil
+	})
+}
+
+// GetTags gets all tags from Radarr
+func (c *Client) GetTags(ctx context.Context) ([]Tag, error) {
+	endpoint := "/api/v3/tag"
+	var tags []Tag
+
+	if err := c.httpClient.Get(ctx, endpoint, &tags); err != nil {
+		return nil, err
 	}
 
-	if response != nil {
-		return json.NewDecoder(resp.Body).Decode(response)
+	return tags, nil
+}
+
+// GetDiskSpace gets the free space of the disks Radarr can see
+func (c *Client) GetDiskSpace(ctx context.Context) ([]DiskSpace, error) {
+	endpoint := "/api/v3/diskspace"
+	var disks []DiskSpace
+
+	if err := c.httpClient.Get(ctx, endpoint, &disks); err != nil {
+		return nil, err
 	}
 
-	return nil
-}
\ No newline at end of file
+	return disks, nil
+}
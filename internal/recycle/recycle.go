@@ -0,0 +1,196 @@
+// Package recycle keeps deleted media in a holding directory until it is purged
+package recycle
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Recycled folders are named "<time>_<original name>", or "<time>-<n>_<original name>"
+// when something with the same name was recycled within the same second
+const timeLayout = "20060102T150405"
+
+// Bin is a directory holding recycled media folders
+type Bin struct {
+	dir       string
+	retention time.Duration
+}
+
+// Item is a folder in the recycle bin
+type Item struct {
+	Path       string
+	Name       string // Original folder name
+	RecycledAt time.Time
+}
+
+// New creates a recycle bin in dir that keeps media for retentionDays
+func New(dir string, retentionDays int) *Bin {
+	return &Bin{
+		dir:       dir,
+		retention: time.Duration(retentionDays) * 24 * time.Hour,
+	}
+}
+
+// Move moves path into the bin and returns its new location. The bin must be
+// on the same filesystem as path, since media folders are too large to copy.
+func (b *Bin) Move(path string, now time.Time) (string, error) {
+	if err := os.MkdirAll(b.dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create recycle bin: %w", err)
+	}
+
+	dest, err := b.destination(filepath.Base(path), now)
+	if err != nil {
+		return "", err
+	}
+	if err := os.Rename(path, dest); err != nil {
+		return "", fmt.Errorf("failed to move %s to recycle bin: %w", path, err)
+	}
+	return dest, nil
+}
+
+// MoveFiles moves some of the files below root into the bin, keeping their
+// paths relative to root, and returns the folder holding them. Files moved
+// before a failure stay in that folder.
+func (b *Bin) MoveFiles(root string, files []string, now time.Time) (string, error) {
+	if err := os.MkdirAll(b.dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create recycle bin: %w", err)
+	}
+	dest, err := b.destination(filepath.Base(root), now)
+	if err != nil {
+		return "", err
+	}
+	if err := os.Mkdir(dest, 0755); err != nil {
+		return "", fmt.Errorf("failed to create recycle bin: %w", err)
+	}
+
+	for _, file := range files {
+		rel, err := filepath.Rel(root, file)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return dest, fmt.Errorf("%s is not below %s", file, root)
+		}
+
+		target := filepath.Join(dest, rel)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return dest, fmt.Errorf("failed to create recycle bin: %w", err)
+		}
+		if err := os.Rename(file, target); err != nil {
+			return dest, fmt.Errorf("failed to move %s to recycle bin: %w", file, err)
+		}
+	}
+	return dest, nil
+}
+
+// destination returns a path in the bin for a folder recycled now that isn't
+// taken yet, so that restoring one recycled folder never touches another
+func (b *Bin) destination(name string, now time.Time) (string, error) {
+	stamp := now.UTC().Format(timeLayout)
+	for n := 1; ; n++ {
+		prefix := stamp
+		if n > 1 {
+			prefix += "-" + strconv.Itoa(n)
+		}
+		dest := filepath.Join(b.dir, prefix+"_"+name)
+		_, err := os.Lstat(dest)
+		if os.IsNotExist(err) {
+			return dest, nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to check recycle bin: %w", err)
+		}
+	}
+}
+
+// RestoreFiles moves the files of a folder recycled by MoveFiles back below
+// root, which may already exist. Files that exist in root again are left in
+// the bin.
+func RestoreFiles(recycled, root string) error {
+	var conflicts []string
+	err := filepath.Walk(recycled, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(recycled, path)
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(root, rel)
+		if _, err := os.Stat(target); err == nil {
+			conflicts = append(conflicts, target)
+			return nil
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		return os.Rename(path, target)
+	})
+	if err != nil {
+		return err
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("%s already exist", strings.Join(conflicts, ", "))
+	}
+	return os.RemoveAll(recycled)
+}
+
+// Restore moves a recycled folder back to path
+func Restore(recycled, path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists", path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.Rename(recycled, path)
+}
+
+// Expired lists the recycled folders older than the retention period, oldest first
+func (b *Bin) Expired(now time.Time) ([]Item, error) {
+	files, err := ioutil.ReadDir(b.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []Item
+	for _, file := range files {
+		parts := strings.SplitN(file.Name(), "_", 2)
+		if len(parts) != 2 {
+			continue // Not recycled by us
+		}
+		stamp := strings.SplitN(parts[0], "-", 2)[0]
+		recycledAt, err := time.Parse(timeLayout, stamp)
+		if err != nil {
+			continue
+		}
+		if now.Sub(recycledAt) < b.retention {
+			continue
+		}
+		expired = append(expired, Item{
+			Path:       filepath.Join(b.dir, file.Name()),
+			Name:       parts[1],
+			RecycledAt: recycledAt,
+		})
+	}
+
+	sort.Slice(expired, func(i, j int) bool {
+		return expired[i].RecycledAt.Before(expired[j].RecycledAt)
+	})
+	return expired, nil
+}
+
+// Purge permanently deletes a recycled folder
+func (b *Bin) Purge(item Item) error {
+	if filepath.Dir(item.Path) != filepath.Clean(b.dir) {
+		return fmt.Errorf("%s is not in the recycle bin", item.Path)
+	}
+	return os.RemoveAll(item.Path)
+}
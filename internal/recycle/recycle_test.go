@@ -0,0 +1,151 @@
+package recycle
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMoveAndRestoreFiles(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "tv", "Old Show")
+	season1 := filepath.Join(root, "Season 01", "e01.mkv")
+	season2 := filepath.Join(root, "Season 02", "e01.mkv")
+	writeFile(t, season1, "s1")
+	writeFile(t, season2, "s2")
+
+	bin := New(filepath.Join(dir, "recycle"), 30)
+	recycled, err := bin.MoveFiles(root, []string{season1}, time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("MoveFiles: %v", err)
+	}
+	if filepath.Base(recycled) != "20261001T000000_Old Show" {
+		t.Errorf("recycled folder = %s", recycled)
+	}
+	if _, err := os.Stat(filepath.Join(recycled, "Season 01", "e01.mkv")); err != nil {
+		t.Errorf("file isn't in the bin: %v", err)
+	}
+	if _, err := os.Stat(season2); err != nil {
+		t.Errorf("other season was moved: %v", err)
+	}
+
+	if err := RestoreFiles(recycled, root); err != nil {
+		t.Fatalf("RestoreFiles: %v", err)
+	}
+	if _, err := os.Stat(season1); err != nil {
+		t.Errorf("file wasn't restored: %v", err)
+	}
+	if _, err := os.Stat(recycled); !os.IsNotExist(err) {
+		t.Errorf("recycled folder is still there: %v", err)
+	}
+}
+
+func TestMoveFilesOutsideRoot(t *testing.T) {
+	dir := t.TempDir()
+	other := filepath.Join(dir, "tv", "Other Show", "e01.mkv")
+	writeFile(t, other, "other")
+
+	bin := New(filepath.Join(dir, "recycle"), 30)
+	if _, err := bin.MoveFiles(filepath.Join(dir, "tv", "Old Show"), []string{other}, time.Now()); err == nil {
+		t.Error("MoveFiles moved a file from outside the root")
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("file was moved: %v", err)
+	}
+}
+
+func TestRestoreFilesKeepsConflicts(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "tv", "Old Show")
+	recycled := filepath.Join(dir, "recycle", "20261001T000000_Old Show")
+	writeFile(t, filepath.Join(recycled, "e01.mkv"), "old")
+	writeFile(t, filepath.Join(root, "e01.mkv"), "new")
+
+	if err := RestoreFiles(recycled, root); err == nil {
+		t.Error("RestoreFiles overwrote a file")
+	}
+	content, _ := ioutil.ReadFile(filepath.Join(root, "e01.mkv"))
+	if string(content) != "new" {
+		t.Errorf("file content = %q, want the newer file kept", content)
+	}
+	if _, err := os.Stat(filepath.Join(recycled, "e01.mkv")); err != nil {
+		t.Errorf("conflicting file was dropped from the bin: %v", err)
+	}
+}
+
+func TestMoveFilesSameSecond(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "tv", "Old Show")
+	season1 := filepath.Join(root, "Season 01", "e01.mkv")
+	season2 := filepath.Join(root, "Season 02", "e01.mkv")
+	writeFile(t, season1, "s1")
+	writeFile(t, season2, "s2")
+
+	bin := New(filepath.Join(dir, "recycle"), 30)
+	now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	first, err := bin.MoveFiles(root, []string{season1}, now)
+	if err != nil {
+		t.Fatalf("MoveFiles: %v", err)
+	}
+	second, err := bin.MoveFiles(root, []string{season2}, now)
+	if err != nil {
+		t.Fatalf("MoveFiles: %v", err)
+	}
+	if first == second {
+		t.Fatalf("both seasons were recycled into %s", first)
+	}
+
+	// Restoring the first season leaves the second in the bin
+	if err := RestoreFiles(first, root); err != nil {
+		t.Fatalf("RestoreFiles: %v", err)
+	}
+	if _, err := os.Stat(season2); !os.IsNotExist(err) {
+		t.Errorf("second season was restored along with the first: %v", err)
+	}
+	if err := RestoreFiles(second, root); err != nil {
+		t.Fatalf("RestoreFiles: %v", err)
+	}
+	if _, err := os.Stat(season2); err != nil {
+		t.Errorf("second season wasn't restored: %v", err)
+	}
+}
+
+func TestMoveSameNameSameSecond(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "movies", "Heat", "heat.mkv"), "1995")
+	writeFile(t, filepath.Join(dir, "movies-4k", "Heat", "heat.mkv"), "4k")
+
+	bin := New(filepath.Join(dir, "recycle"), 30)
+	now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	first, err := bin.Move(filepath.Join(dir, "movies", "Heat"), now)
+	if err != nil {
+		t.Fatalf("Move: %v", err)
+	}
+	second, err := bin.Move(filepath.Join(dir, "movies-4k", "Heat"), now)
+	if err != nil {
+		t.Fatalf("Move: %v", err)
+	}
+	if first == second {
+		t.Fatalf("both folders were recycled into %s", first)
+	}
+
+	expired, err := bin.Expired(now.AddDate(0, 0, 31))
+	if err != nil {
+		t.Fatalf("Expired: %v", err)
+	}
+	if len(expired) != 2 || expired[0].Name != "Heat" || expired[1].Name != "Heat" {
+		t.Errorf("expired = %+v, want both folders named Heat", expired)
+	}
+}
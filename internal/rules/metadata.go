@@ -0,0 +1,159 @@
+package rules
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alex4108/jellycleaner/internal/jellyfin"
+	"github.com/alex4108/jellycleaner/internal/jellyseerr"
+)
+
+// Metadata provides the item information that rules evaluate
+type Metadata interface {
+	AddedDate() (time.Time, error)
+	WatchedByAllUsers(ctx context.Context) (bool, error)
+	PlayedByAnyUser(ctx context.Context) (bool, error)
+	PlayStates(ctx context.Context) ([]jellyfin.UserPlayState, error)
+	// Requester returns the Jellyfin user who requested the item in Jellyseerr,
+	// or nil if nobody requested it or the requester has no Jellyfin account
+	Requester(ctx context.Context) (*jellyfin.User, error)
+}
+
+// Sources provide the metadata that isn't part of the item itself. They
+// should serve it from a snapshot loaded once per run rather than make a
+// request per item.
+type Sources struct {
+	// PlayStates returns every user's playback information for an item
+	PlayStates func(ctx context.Context, item jellyfin.Item) ([]jellyfin.UserPlayState, error)
+	// Requests returns the Jellyseerr requests
+	Requests func(ctx context.Context) (*jellyseerr.RequestIndex, error)
+	// UserMap maps Jellyseerr users to Jellyfin users, by name or ID
+	UserMap map[string]string
+}
+
+// jellyfinMetadata reads metadata from the item and its sources on first use
+// and caches it
+type jellyfinMetadata struct {
+	sources Sources
+	item    jellyfin.Item
+
+	playStates []jellyfin.UserPlayState
+}
+
+// NewMetadata returns Metadata for an item backed by the given sources
+func NewMetadata(sources Sources, item jellyfin.Item) Metadata {
+	return &jellyfinMetadata{sources: sources, item: item}
+}
+
+func (m *jellyfinMetadata) AddedDate() (time.Time, error) {
+	if m.item.AddedDate.IsZero() {
+		return time.Time{}, fmt.Errorf("added date of %s is unknown", m.item.Name)
+	}
+	return m.item.AddedDate, nil
+}
+
+func (m *jellyfinMetadata) PlayStates(ctx context.Context) ([]jellyfin.UserPlayState, error) {
+	if m.playStates == nil {
+		playStates, err := m.sources.PlayStates(ctx, m.item)
+		if err != nil {
+			return nil, err
+		}
+		m.playStates = playStates
+	}
+	return m.playStates, nil
+}
+
+func (m *jellyfinMetadata) WatchedByAllUsers(ctx context.Context) (bool, error) {
+	playStates, err := m.PlayStates(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	for _, state := range playStates {
+		if !state.Played {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (m *jellyfinMetadata) PlayedByAnyUser(ctx context.Context) (bool, error) {
+	playStates, err := m.PlayStates(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	for _, state := range playStates {
+		if state.Played || state.PlayCount > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *jellyfinMetadata) Requester(ctx context.Context) (*jellyfin.User, error) {
+	if m.sources.Requests == nil {
+		return nil, nil
+	}
+
+	requests, err := m.sources.Requests(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	mediaType := strings.ToLower(m.item.Type)
+	request := requests.Find(mediaType, m.item.ExternalID)
+	if request == nil {
+		return nil, nil
+	}
+
+	playStates, err := m.PlayStates(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, candidate := range m.requesterCandidates(request.RequestedBy) {
+		for _, state := range playStates {
+			if sameUserID(state.User.ID, candidate) || strings.EqualFold(state.User.Name, candidate) {
+				user := state.User
+				return &user, nil
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// requesterCandidates lists the Jellyfin names or IDs the requester may have,
+// with an explicit mapping taking precedence over the linked Jellyfin account
+func (m *jellyfinMetadata) requesterCandidates(requester jellyseerr.User) []string {
+	var candidates []string
+	for _, key := range []string{requester.Name, requester.Username, requester.Email, strconv.Itoa(requester.ID)} {
+		if key == "" {
+			continue
+		}
+		for from, to := range m.sources.UserMap {
+			if strings.EqualFold(from, key) {
+				candidates = append(candidates, to)
+			}
+		}
+	}
+
+	for _, candidate := range []string{requester.JellyfinUserID, requester.JellyfinUsername, requester.Username, requester.Name} {
+		if candidate != "" {
+			candidates = append(candidates, candidate)
+		}
+	}
+	return candidates
+}
+
+// sameUserID compares Jellyfin user IDs, which may be formatted with or without dashes
+func sameUserID(a, b string) bool {
+	normalize := func(id string) string {
+		return strings.ToLower(strings.ReplaceAll(id, "-", ""))
+	}
+	return normalize(a) == normalize(b)
+}
@@ -0,0 +1,101 @@
+package rules
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alex4108/jellycleaner/internal/jellyfin"
+	"github.com/alex4108/jellycleaner/internal/jellyseerr"
+)
+
+func TestPlayedByUsers(t *testing.T) {
+	tests := []struct {
+		name         string
+		playStates   []jellyfin.UserPlayState
+		watchedByAll bool
+		playedByAny  bool
+	}{
+		{"nobody", []jellyfin.UserPlayState{unplayed(alice), unplayed(bob)}, false, false},
+		{"somebody", []jellyfin.UserPlayState{played(alice, 1), unplayed(bob)}, false, true},
+		{"everybody", []jellyfin.UserPlayState{played(alice, 1), played(bob, 1)}, true, true},
+		{"started but not finished", []jellyfin.UserPlayState{{User: alice, PlayCount: 1}}, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := jellyfin.Item{ID: "item"}
+			md := NewMetadata(Sources{
+				PlayStates: func(ctx context.Context, item jellyfin.Item) ([]jellyfin.UserPlayState, error) {
+					return tt.playStates, nil
+				},
+			}, item)
+
+			watchedByAll, err := md.WatchedByAllUsers(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			playedByAny, err := md.PlayedByAnyUser(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if watchedByAll != tt.watchedByAll {
+				t.Errorf("WatchedByAllUsers = %v, want %v", watchedByAll, tt.watchedByAll)
+			}
+			if playedByAny != tt.playedByAny {
+				t.Errorf("PlayedByAnyUser = %v, want %v", playedByAny, tt.playedByAny)
+			}
+		})
+	}
+}
+
+func TestRequester(t *testing.T) {
+	playStates := []jellyfin.UserPlayState{
+		unplayed(jellyfin.User{ID: "0a1b2c3d-0000-0000-0000-000000000001", Name: "alice"}),
+		unplayed(jellyfin.User{ID: "u2", Name: "bob"}),
+	}
+
+	tests := []struct {
+		name      string
+		requester jellyseerr.User
+		userMap   map[string]string
+		want      string
+	}{
+		{"linked account", jellyseerr.User{JellyfinUserID: "0a1b2c3d000000000000000000000001"}, nil, "alice"},
+		{"linked user name", jellyseerr.User{JellyfinUsername: "Bob"}, nil, "bob"},
+		{"display name", jellyseerr.User{Name: "bob"}, nil, "bob"},
+		{"mapped by email", jellyseerr.User{Email: "a@example.com", Name: "Alice Smith"}, map[string]string{"A@example.com": "alice"}, "alice"},
+		{"mapping wins over linked account", jellyseerr.User{ID: 7, JellyfinUsername: "alice"}, map[string]string{"7": "bob"}, "bob"},
+		{"no Jellyfin account", jellyseerr.User{Name: "Carol"}, nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := jellyseerr.MediaRequest{ID: 1, MediaType: "movie", RequestedBy: tt.requester}
+			request.Media.TMDBID = 603
+			index := jellyseerr.NewRequestIndex([]jellyseerr.MediaRequest{request})
+
+			item := jellyfin.Item{ID: "item", Type: "Movie", ExternalID: "603"}
+			md := NewMetadata(Sources{
+				PlayStates: func(ctx context.Context, item jellyfin.Item) ([]jellyfin.UserPlayState, error) {
+					return playStates, nil
+				},
+				Requests: func(ctx context.Context) (*jellyseerr.RequestIndex, error) {
+					return index, nil
+				},
+				UserMap: tt.userMap,
+			}, item)
+
+			user, err := md.Requester(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ""
+			if user != nil {
+				got = user.Name
+			}
+			if got != tt.want {
+				t.Errorf("Requester = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,442 @@
+package rules
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alex4108/jellycleaner/config"
+	"github.com/alex4108/jellycleaner/internal/jellyfin"
+)
+
+// Result is the outcome of evaluating a rule against an item
+type Result struct {
+	Matched bool
+	Rule    string // Name of the rule that fired
+	Reason  string // Human readable explanation
+}
+
+// Rule decides whether an item should be marked for deletion
+type Rule interface {
+	Name() string
+	Evaluate(ctx context.Context, item jellyfin.Item, md Metadata) (Result, error)
+}
+
+// Build compiles a library's rules into a single rule. Conditions within one
+// block are combined with "any", matching the behaviour of the flat rules;
+// use "all" and "not" to build stricter policies. It returns nil if the
+// block has no conditions, meaning nothing is ever marked.
+func Build(cfg config.LibraryRules) (Rule, error) {
+	var rules []Rule
+
+	if cfg.DeleteIfWatchedByAll {
+		rules = append(rules, WatchedByAll{})
+	}
+	if cfg.MaxAgeDays > 0 {
+		rules = append(rules, MaxAge{Days: cfg.MaxAgeDays})
+	}
+	if cfg.NeverPlayed {
+		rules = append(rules, NeverPlayed{})
+	}
+	if cfg.MaxDaysSinceLastPlayed > 0 {
+		rules = append(rules, MaxDaysSinceLastPlayed{Days: cfg.MaxDaysSinceLastPlayed})
+	}
+	if cfg.WatchedBy != nil {
+		rules = append(rules, WatchedBy{
+			Users:           cfg.WatchedBy.Users,
+			ExcludeUsers:    cfg.WatchedBy.ExcludeUsers,
+			MinUsers:        cfg.WatchedBy.MinUsers,
+			IncludeDisabled: cfg.WatchedBy.IncludeDisabled,
+		})
+	}
+	if cfg.WatchedByRequester != nil {
+		rules = append(rules, WatchedByRequester{GraceDays: cfg.WatchedByRequester.GraceDays})
+	}
+	if len(cfg.All) > 0 {
+		children, err := buildAll(cfg.All)
+		if err != nil {
+			return nil, fmt.Errorf("all: %w", err)
+		}
+		rules = append(rules, All{Rules: children})
+	}
+	if len(cfg.Any) > 0 {
+		children, err := buildAll(cfg.Any)
+		if err != nil {
+			return nil, fmt.Errorf("any: %w", err)
+		}
+		rules = append(rules, Any{Rules: children})
+	}
+	if cfg.Not != nil {
+		child, err := buildRequired(*cfg.Not)
+		if err != nil {
+			return nil, fmt.Errorf("not: %w", err)
+		}
+		rules = append(rules, Not{Rule: child})
+	}
+
+	switch len(rules) {
+	case 0:
+		return nil, nil
+	case 1:
+		return rules[0], nil
+	default:
+		return Any{Rules: rules}, nil
+	}
+}
+
+// CheckUsers verifies the users named anywhere in a rule against the Jellyfin
+// users. A nil rule is valid.
+func CheckUsers(rule Rule, users []jellyfin.User) error {
+	switch r := rule.(type) {
+	case WatchedBy:
+		return r.CheckUsers(users)
+	case All:
+		return checkAllUsers(r.Rules, users)
+	case Any:
+		return checkAllUsers(r.Rules, users)
+	case Not:
+		return CheckUsers(r.Rule, users)
+	}
+	return nil
+}
+
+func checkAllUsers(rules []Rule, users []jellyfin.User) error {
+	for _, rule := range rules {
+		if err := CheckUsers(rule, users); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func buildAll(cfgs []config.LibraryRules) ([]Rule, error) {
+	rules := make([]Rule, 0, len(cfgs))
+	for i, cfg := range cfgs {
+		rule, err := buildRequired(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// buildRequired builds a nested block, which must contain at least one condition
+func buildRequired(cfg config.LibraryRules) (Rule, error) {
+	rule, err := Build(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if rule == nil {
+		return nil, fmt.Errorf("empty rule block")
+	}
+	return rule, nil
+}
+
+// WatchedByAll matches items that every Jellyfin user has played
+type WatchedByAll struct{}
+
+func (WatchedByAll) Name() string { return "delete_if_watched_by_all" }
+
+func (r WatchedByAll) Evaluate(ctx context.Context, item jellyfin.Item, md Metadata) (Result, error) {
+	watchedByAll, err := md.WatchedByAllUsers(ctx)
+	if err != nil {
+		return Result{}, fmt.Errorf("checking if %s is watched by all: %w", item.Name, err)
+	}
+	if !watchedByAll {
+		return Result{}, nil
+	}
+	return Result{Matched: true, Rule: r.Name(), Reason: "Watched by all users"}, nil
+}
+
+// MaxAge matches items that were added more than Days days ago
+type MaxAge struct {
+	Days int
+}
+
+func (r MaxAge) Name() string { return fmt.Sprintf("max_age_days(%d)", r.Days) }
+
+func (r MaxAge) Evaluate(ctx context.Context, item jellyfin.Item, md Metadata) (Result, error) {
+	addedDate, err := md.AddedDate()
+	if err != nil {
+		return Result{}, fmt.Errorf("getting added date for %s: %w", item.Name, err)
+	}
+
+	ageInDays := int(time.Since(addedDate).Hours() / 24)
+	if ageInDays <= r.Days {
+		return Result{}, nil
+	}
+	return Result{Matched: true, Rule: r.Name(), Reason: "Exceeds maximum age"}, nil
+}
+
+// NeverPlayed matches items that no Jellyfin user has played
+type NeverPlayed struct{}
+
+func (NeverPlayed) Name() string { return "never_played" }
+
+func (r NeverPlayed) Evaluate(ctx context.Context, item jellyfin.Item, md Metadata) (Result, error) {
+	played, err := md.PlayedByAnyUser(ctx)
+	if err != nil {
+		return Result{}, fmt.Errorf("checking if %s was played: %w", item.Name, err)
+	}
+	if played {
+		return Result{}, nil
+	}
+	return Result{Matched: true, Rule: r.Name(), Reason: "Never played"}, nil
+}
+
+// MaxDaysSinceLastPlayed matches items that nobody has played in the last
+// Days days. Items that were never played fall back to their added date.
+type MaxDaysSinceLastPlayed struct {
+	Days int
+}
+
+func (r MaxDaysSinceLastPlayed) Name() string {
+	return fmt.Sprintf("max_days_since_last_played(%d)", r.Days)
+}
+
+func (r MaxDaysSinceLastPlayed) Evaluate(ctx context.Context, item jellyfin.Item, md Metadata) (Result, error) {
+	playStates, err := md.PlayStates(ctx)
+	if err != nil {
+		return Result{}, fmt.Errorf("getting play states for %s: %w", item.Name, err)
+	}
+
+	var lastPlayed time.Time
+	for _, state := range playStates {
+		if state.LastPlayedDate.After(lastPlayed) {
+			lastPlayed = state.LastPlayedDate
+		}
+	}
+
+	reason := fmt.Sprintf("Not played in %d days", r.Days)
+	if lastPlayed.IsZero() {
+		lastPlayed, err = md.AddedDate()
+		if err != nil {
+			return Result{}, fmt.Errorf("getting added date for %s: %w", item.Name, err)
+		}
+		reason = fmt.Sprintf("Never played and added more than %d days ago", r.Days)
+	}
+
+	daysSince := int(time.Since(lastPlayed).Hours() / 24)
+	if daysSince <= r.Days {
+		return Result{}, nil
+	}
+	return Result{Matched: true, Rule: r.Name(), Reason: reason}, nil
+}
+
+// WatchedBy matches items that at least MinUsers of the selected users have
+// played. Users are selected by name or ID; an empty Users list selects every
+// user. Disabled accounts are ignored unless IncludeDisabled is set.
+type WatchedBy struct {
+	Users           []string
+	ExcludeUsers    []string
+	MinUsers        int // 0 means all selected users
+	IncludeDisabled bool
+}
+
+func (r WatchedBy) Name() string {
+	if r.MinUsers > 0 {
+		return fmt.Sprintf("watched_by(min %d)", r.MinUsers)
+	}
+	return "watched_by(all)"
+}
+
+func (r WatchedBy) Evaluate(ctx context.Context, item jellyfin.Item, md Metadata) (Result, error) {
+	playStates, err := md.PlayStates(ctx)
+	if err != nil {
+		return Result{}, fmt.Errorf("getting play states for %s: %w", item.Name, err)
+	}
+
+	selected, watched := 0, 0
+	for _, state := range playStates {
+		if !r.selects(state.User) {
+			continue
+		}
+		selected++
+		if state.Played {
+			watched++
+		}
+	}
+
+	required := r.MinUsers
+	if required <= 0 {
+		required = selected
+	}
+	if selected == 0 || watched < required {
+		return Result{}, nil
+	}
+	return Result{
+		Matched: true,
+		Rule:    r.Name(),
+		Reason:  fmt.Sprintf("Watched by %d of %d users", watched, selected),
+	}, nil
+}
+
+// CheckUsers reports users named in the rule that don't exist and a MinUsers
+// that more users are required for than are selected. Either would otherwise
+// quietly make the rule stricter or looser than configured.
+func (r WatchedBy) CheckUsers(users []jellyfin.User) error {
+	var unknown []string
+	for _, name := range append(append([]string{}, r.Users...), r.ExcludeUsers...) {
+		found := false
+		for _, user := range users {
+			if matchesUser(user, []string{name}) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("watched_by: unknown users: %s", strings.Join(unknown, ", "))
+	}
+
+	selected := 0
+	for _, user := range users {
+		if r.selects(user) {
+			selected++
+		}
+	}
+	if r.MinUsers > selected {
+		return fmt.Errorf("watched_by: min_users %d is more than the %d selected users", r.MinUsers, selected)
+	}
+	return nil
+}
+
+func (r WatchedBy) selects(user jellyfin.User) bool {
+	if user.IsDisabled && !r.IncludeDisabled {
+		return false
+	}
+	if matchesUser(user, r.ExcludeUsers) {
+		return false
+	}
+	return len(r.Users) == 0 || matchesUser(user, r.Users)
+}
+
+func matchesUser(user jellyfin.User, names []string) bool {
+	for _, name := range names {
+		if user.ID == name || strings.EqualFold(user.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// WatchedByRequester matches items that the user who requested them in
+// Jellyseerr has played, once GraceDays have passed since they last played it.
+// Items nobody requested never match, leaving them to the library's other rules.
+type WatchedByRequester struct {
+	GraceDays int
+}
+
+func (r WatchedByRequester) Name() string {
+	return fmt.Sprintf("watched_by_requester(grace %d)", r.GraceDays)
+}
+
+func (r WatchedByRequester) Evaluate(ctx context.Context, item jellyfin.Item, md Metadata) (Result, error) {
+	requester, err := md.Requester(ctx)
+	if err != nil {
+		return Result{}, fmt.Errorf("getting requester for %s: %w", item.Name, err)
+	}
+	if requester == nil {
+		return Result{}, nil
+	}
+
+	playStates, err := md.PlayStates(ctx)
+	if err != nil {
+		return Result{}, fmt.Errorf("getting play states for %s: %w", item.Name, err)
+	}
+
+	for _, state := range playStates {
+		if state.User.ID != requester.ID || !state.Played {
+			continue
+		}
+		if r.GraceDays > 0 && !state.LastPlayedDate.IsZero() &&
+			time.Since(state.LastPlayedDate) < time.Duration(r.GraceDays)*24*time.Hour {
+			return Result{}, nil
+		}
+		return Result{
+			Matched: true,
+			Rule:    r.Name(),
+			Reason:  fmt.Sprintf("Watched by requester %s", requester.Name),
+		}, nil
+	}
+
+	return Result{}, nil
+}
+
+// All matches when every child rule matches
+type All struct {
+	Rules []Rule
+}
+
+func (r All) Name() string { return "all(" + joinNames(r.Rules) + ")" }
+
+func (r All) Evaluate(ctx context.Context, item jellyfin.Item, md Metadata) (Result, error) {
+	var reasons []string
+	for _, rule := range r.Rules {
+		result, err := rule.Evaluate(ctx, item, md)
+		if err != nil {
+			return Result{}, err
+		}
+		if !result.Matched {
+			return Result{}, nil
+		}
+		reasons = append(reasons, result.Reason)
+	}
+	return Result{Matched: true, Rule: r.Name(), Reason: strings.Join(reasons, " and ")}, nil
+}
+
+// Any matches when at least one child rule matches. A child that fails to
+// evaluate is treated as not matching, so the remaining rules still apply.
+type Any struct {
+	Rules []Rule
+}
+
+func (r Any) Name() string { return "any(" + joinNames(r.Rules) + ")" }
+
+func (r Any) Evaluate(ctx context.Context, item jellyfin.Item, md Metadata) (Result, error) {
+	var firstErr error
+	for _, rule := range r.Rules {
+		result, err := rule.Evaluate(ctx, item, md)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if result.Matched {
+			return result, nil
+		}
+	}
+	return Result{}, firstErr
+}
+
+// Not matches when its child rule does not match
+type Not struct {
+	Rule Rule
+}
+
+func (r Not) Name() string { return "not(" + r.Rule.Name() + ")" }
+
+func (r Not) Evaluate(ctx context.Context, item jellyfin.Item, md Metadata) (Result, error) {
+	result, err := r.Rule.Evaluate(ctx, item, md)
+	if err != nil {
+		return Result{}, err
+	}
+	if result.Matched {
+		return Result{}, nil
+	}
+	return Result{Matched: true, Rule: r.Name(), Reason: "Does not match " + r.Rule.Name()}, nil
+}
+
+func joinNames(rules []Rule) string {
+	names := make([]string, len(rules))
+	for i, rule := range rules {
+		names[i] = rule.Name()
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,295 @@
+package rules
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alex4108/jellycleaner/config"
+	"github.com/alex4108/jellycleaner/internal/jellyfin"
+)
+
+var (
+	alice = jellyfin.User{ID: "u1", Name: "alice"}
+	bob   = jellyfin.User{ID: "u2", Name: "bob"}
+	carol = jellyfin.User{ID: "u3", Name: "carol"}
+	admin = jellyfin.User{ID: "u4", Name: "admin"}
+	guest = jellyfin.User{ID: "u5", Name: "guest", IsDisabled: true}
+)
+
+func daysAgo(days int) time.Time {
+	return time.Now().AddDate(0, 0, -days)
+}
+
+func played(user jellyfin.User, days int) jellyfin.UserPlayState {
+	return jellyfin.UserPlayState{User: user, Played: true, PlayCount: 1, LastPlayedDate: daysAgo(days)}
+}
+
+func unplayed(user jellyfin.User) jellyfin.UserPlayState {
+	return jellyfin.UserPlayState{User: user}
+}
+
+// evaluate runs rule against an item added addedDays ago with the given play states
+func evaluate(t *testing.T, rule Rule, addedDays int, playStates ...jellyfin.UserPlayState) Result {
+	t.Helper()
+	item := jellyfin.Item{ID: "item", Name: "Item", Type: "Movie", AddedDate: daysAgo(addedDays)}
+	sources := Sources{
+		PlayStates: func(ctx context.Context, item jellyfin.Item) ([]jellyfin.UserPlayState, error) {
+			return playStates, nil
+		},
+	}
+
+	result, err := rule.Evaluate(context.Background(), item, NewMetadata(sources, item))
+	if err != nil {
+		t.Fatalf("Evaluate: %v", err)
+	}
+	return result
+}
+
+// stubRule returns a fixed result
+type stubRule struct {
+	name    string
+	matched bool
+	err     error
+}
+
+func (r stubRule) Name() string { return r.name }
+
+func (r stubRule) Evaluate(ctx context.Context, item jellyfin.Item, md Metadata) (Result, error) {
+	if r.err != nil {
+		return Result{}, r.err
+	}
+	return Result{Matched: r.matched, Rule: r.name, Reason: r.name}, nil
+}
+
+var (
+	yes    = stubRule{name: "yes", matched: true}
+	no     = stubRule{name: "no"}
+	broken = stubRule{name: "broken", err: errors.New("broken")}
+)
+
+func TestComposition(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    Rule
+		matched bool
+		wantErr bool
+		reason  string
+	}{
+		{"all matching", All{Rules: []Rule{yes, yes}}, true, false, "yes and yes"},
+		{"all with one failing", All{Rules: []Rule{yes, no}}, false, false, ""},
+		{"all with an error", All{Rules: []Rule{yes, broken}}, false, true, ""},
+		{"any with one matching", Any{Rules: []Rule{no, yes}}, true, false, "yes"},
+		{"any with none matching", Any{Rules: []Rule{no, no}}, false, false, ""},
+		{"any skips errors", Any{Rules: []Rule{broken, yes}}, true, false, "yes"},
+		{"any reports errors if nothing matches", Any{Rules: []Rule{broken, no}}, false, true, ""},
+		{"not matching", Not{Rule: no}, true, false, "Does not match no"},
+		{"not failing", Not{Rule: yes}, false, false, ""},
+		{"not with an error", Not{Rule: broken}, false, true, ""},
+		{"nested", All{Rules: []Rule{yes, Any{Rules: []Rule{no, Not{Rule: no}}}}}, true, false, "yes and Does not match no"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := tt.rule.Evaluate(context.Background(), jellyfin.Item{}, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if result.Matched != tt.matched {
+				t.Fatalf("matched = %v, want %v", result.Matched, tt.matched)
+			}
+			if result.Reason != tt.reason {
+				t.Errorf("reason = %q, want %q", result.Reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestBuild(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      config.LibraryRules
+		wantName string
+		wantErr  bool
+	}{
+		{"empty", config.LibraryRules{}, "", false},
+		{"single condition", config.LibraryRules{MaxAgeDays: 30}, "max_age_days(30)", false},
+		{
+			"flat conditions are combined with any",
+			config.LibraryRules{DeleteIfWatchedByAll: true, MaxAgeDays: 30},
+			"any(delete_if_watched_by_all, max_age_days(30))",
+			false,
+		},
+		{
+			"all block",
+			config.LibraryRules{All: []config.LibraryRules{{MaxAgeDays: 365}, {NeverPlayed: true}}},
+			"all(max_age_days(365), never_played)",
+			false,
+		},
+		{
+			"flat condition next to a block",
+			config.LibraryRules{NeverPlayed: true, Not: &config.LibraryRules{MaxAgeDays: 7}},
+			"any(never_played, not(max_age_days(7)))",
+			false,
+		},
+		{"empty nested block", config.LibraryRules{All: []config.LibraryRules{{}}}, "", true},
+		{"empty not block", config.LibraryRules{Not: &config.LibraryRules{}}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := Build(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			name := ""
+			if rule != nil {
+				name = rule.Name()
+			}
+			if name != tt.wantName {
+				t.Errorf("rule = %q, want %q", name, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestBuiltRulesMatchTogether(t *testing.T) {
+	// Older than a year and (watched by everyone or never played)
+	rule, err := Build(config.LibraryRules{All: []config.LibraryRules{
+		{MaxAgeDays: 365},
+		{Any: []config.LibraryRules{{DeleteIfWatchedByAll: true}, {NeverPlayed: true}}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		addedDays  int
+		playStates []jellyfin.UserPlayState
+		matched    bool
+	}{
+		{"old and watched by all", 400, []jellyfin.UserPlayState{played(alice, 10), played(bob, 20)}, true},
+		{"old and never played", 400, []jellyfin.UserPlayState{unplayed(alice), unplayed(bob)}, true},
+		{"old and partly watched", 400, []jellyfin.UserPlayState{played(alice, 10), unplayed(bob)}, false},
+		{"new and watched by all", 30, []jellyfin.UserPlayState{played(alice, 10), played(bob, 20)}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := evaluate(t, rule, tt.addedDays, tt.playStates...); result.Matched != tt.matched {
+				t.Errorf("matched = %v, want %v (%s)", result.Matched, tt.matched, result.Reason)
+			}
+		})
+	}
+}
+
+func TestMaxDaysSinceLastPlayed(t *testing.T) {
+	rule := MaxDaysSinceLastPlayed{Days: 30}
+
+	tests := []struct {
+		name       string
+		addedDays  int
+		playStates []jellyfin.UserPlayState
+		matched    bool
+		reason     string
+	}{
+		{"played recently", 400, []jellyfin.UserPlayState{played(alice, 5)}, false, ""},
+		{"played long ago", 400, []jellyfin.UserPlayState{played(alice, 60)}, true, "Not played in 30 days"},
+		{"most recent play counts", 400, []jellyfin.UserPlayState{played(alice, 60), played(bob, 5)}, false, ""},
+		{"never played and added recently", 10, []jellyfin.UserPlayState{unplayed(alice)}, false, ""},
+		{"never played and added long ago", 60, []jellyfin.UserPlayState{unplayed(alice)}, true, "Never played and added more than 30 days ago"},
+		{"no users", 60, nil, true, "Never played and added more than 30 days ago"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := evaluate(t, rule, tt.addedDays, tt.playStates...)
+			if result.Matched != tt.matched {
+				t.Fatalf("matched = %v, want %v", result.Matched, tt.matched)
+			}
+			if result.Reason != tt.reason {
+				t.Errorf("reason = %q, want %q", result.Reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestMaxDaysSinceLastPlayedWithoutAddedDate(t *testing.T) {
+	item := jellyfin.Item{ID: "item", Name: "Item"}
+	sources := Sources{
+		PlayStates: func(ctx context.Context, item jellyfin.Item) ([]jellyfin.UserPlayState, error) {
+			return []jellyfin.UserPlayState{unplayed(alice)}, nil
+		},
+	}
+
+	rule := MaxDaysSinceLastPlayed{Days: 30}
+	if _, err := rule.Evaluate(context.Background(), item, NewMetadata(sources, item)); err == nil {
+		t.Error("expected an error for an item without an added date")
+	}
+}
+
+func TestWatchedBy(t *testing.T) {
+	everyone := []jellyfin.UserPlayState{played(alice, 1), played(bob, 1), unplayed(carol), unplayed(admin), played(guest, 1)}
+
+	tests := []struct {
+		name       string
+		rule       WatchedBy
+		playStates []jellyfin.UserPlayState
+		matched    bool
+		reason     string
+	}{
+		{"all enabled users", WatchedBy{}, everyone, false, ""},
+		{"excluded users don't count", WatchedBy{ExcludeUsers: []string{"carol", "admin"}}, everyone, true, "Watched by 2 of 2 users"},
+		{"chosen users by name", WatchedBy{Users: []string{"Alice", "bob"}}, everyone, true, "Watched by 2 of 2 users"},
+		{"chosen users by ID", WatchedBy{Users: []string{"u1", "u3"}}, everyone, false, ""},
+		{"min users reached", WatchedBy{Users: []string{"alice", "bob", "carol"}, MinUsers: 2}, everyone, true, "Watched by 2 of 3 users"},
+		{"min users not reached", WatchedBy{Users: []string{"alice", "carol", "admin"}, MinUsers: 2}, everyone, false, ""},
+		{"disabled users are ignored", WatchedBy{Users: []string{"guest"}}, everyone, false, ""},
+		{"disabled users can be included", WatchedBy{Users: []string{"guest"}, IncludeDisabled: true}, everyone, true, "Watched by 1 of 1 users"},
+		{"exclusion wins over selection", WatchedBy{Users: []string{"alice", "carol"}, ExcludeUsers: []string{"carol"}}, everyone, true, "Watched by 1 of 1 users"},
+		{"min users above selection never matches", WatchedBy{Users: []string{"alice"}, MinUsers: 2}, everyone, false, ""},
+		{"no users selected", WatchedBy{Users: []string{"nobody"}}, everyone, false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := evaluate(t, tt.rule, 100, tt.playStates...)
+			if result.Matched != tt.matched {
+				t.Fatalf("matched = %v, want %v (%s)", result.Matched, tt.matched, result.Reason)
+			}
+			if result.Reason != tt.reason {
+				t.Errorf("reason = %q, want %q", result.Reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestCheckUsers(t *testing.T) {
+	users := []jellyfin.User{alice, bob, carol, admin, guest}
+
+	tests := []struct {
+		name    string
+		rule    Rule
+		wantErr bool
+	}{
+		{"nil rule", nil, false},
+		{"rule without users", MaxAge{Days: 1}, false},
+		{"known users", WatchedBy{Users: []string{"Alice", "u2"}, ExcludeUsers: []string{"admin"}}, false},
+		{"unknown user", WatchedBy{Users: []string{"alice", "bobby"}}, true},
+		{"unknown excluded user", WatchedBy{ExcludeUsers: []string{"root"}}, true},
+		{"min users within selection", WatchedBy{Users: []string{"alice", "bob"}, MinUsers: 2}, false},
+		{"min users above selection", WatchedBy{Users: []string{"alice", "bob"}, MinUsers: 3}, true},
+		{"disabled users aren't selected", WatchedBy{Users: []string{"alice", "guest"}, MinUsers: 2}, true},
+		{"nested unknown user", All{Rules: []Rule{MaxAge{Days: 1}, Not{Rule: WatchedBy{Users: []string{"dave"}}}}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CheckUsers(tt.rule, users); (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,46 +1,104 @@
 package sonarr
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"net/http"
 	"net/url"
 	"strconv"
-	"strings"
-	"time"
+
+	"github.com/alex4108/jellycleaner/internal/httpx"
 )
 
 // Client handles communication with the Sonarr API
 type Client struct {
-	baseURL    string
-	apiKey     string
-	httpClient *http.Client
+	httpClient *httpx.Client
 }
 
 // Series represents a TV series in Sonarr
 type Series struct {
-	ID      int    `json:"id"`
-	Title   string `json:"title"`
-	TVDBID  int    `json:"tvdbId"`
-	Path    string `json:"path"`
+	ID                int            `json:"id"`
+	Title             string         `json:"title"`
+	Year              int            `json:"year"`
+	TVDBID            int            `json:"tvdbId"`
+	Path              string         `json:"path"`
+	RootFolderPath    string         `json:"rootFolderPath"`
+	QualityProfileID  int            `json:"qualityProfileId"`
+	LanguageProfileID int            `json:"languageProfileId"`
+	Tags              []int          `json:"tags"`
+	Monitored         bool           `json:"monitored"`
+	SeasonFolder      bool           `json:"seasonFolder"`
+	SeriesType        string         `json:"seriesType"`
+	Seasons           []SeriesSeason `json:"seasons"`
+	Statistics        struct {
+		SizeOnDisk int64 `json:"sizeOnDisk"`
+	} `json:"statistics"`
+}
+
+// SeriesSeason represents a season of a series in Sonarr
+type SeriesSeason struct {
+	SeasonNumber int  `json:"seasonNumber"`
+	Monitored    bool `json:"monitored"`
+	Statistics   struct {
+		EpisodeFileCount int   `json:"episodeFileCount"`
+		SizeOnDisk       int64 `json:"sizeOnDisk"`
+	} `json:"statistics"`
 }
 
-// NewClient creates a new Sonarr client
-func NewClient(baseURL, apiKey string) (*Client, error) {
-	// Ensure baseURL doesn't end with a slash
-	baseURL = strings.TrimSuffix(baseURL, "/")
+// EpisodeFile represents an episode file on disk in Sonarr
+type EpisodeFile struct {
+	ID           int    `json:"id"`
+	SeriesID     int    `json:"seriesId"`
+	SeasonNumber int    `json:"seasonNumber"`
+	Path         string `json:"path"`
+	Size         int64  `json:"size"`
+}
+
+// Episode represents an episode of a series in Sonarr
+type Episode struct {
+	ID            int `json:"id"`
+	SeriesID      int `json:"seriesId"`
+	SeasonNumber  int `json:"seasonNumber"`
+	EpisodeNumber int `json:"episodeNumber"`
+	EpisodeFileID int `json:"episodeFileId"` // 0 if the episode has no file
+}
+
+// QualityProfile represents a quality profile in Sonarr
+type QualityProfile struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// Tag represents a tag in Sonarr
+type Tag struct {
+	ID    int    `json:"id"`
+	Label string `json:"label"`
+}
+
+// DiskSpace represents a disk as reported by Sonarr
+type DiskSpace struct {
+	Path       string `json:"path"`
+	Label      string `json:"label"`
+	FreeSpace  int64  `json:"freeSpace"`
+	TotalSpace int64  `json:"totalSpace"`
+}
+
+// NewClient creates a new Sonarr client. opts configure retries and rate
+// limiting; authentication is added by the client.
+func NewClient(baseURL, apiKey string, opts httpx.Options) (*Client, error) {
+	opts.Auth = func(req *http.Request) {
+		q := req.URL.Query()
+		q.Set("apikey", apiKey)
+		req.URL.RawQuery = q.Encode()
+	}
 
 	return &Client{
-		baseURL: baseURL,
-		apiKey:  apiKey,
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+		httpClient: httpx.New(baseURL, opts),
 	}, nil
 }
 
 // GetSeriesByTVDBID gets a series by its TVDB ID
-func (c *Client) GetSeriesByTVDBID(tvdbID string) (*Series, error) {
+func (c *Client) GetSeriesByTVDBID(ctx context.Context, tvdbID string) (*Series, error) {
 	// Convert string to int
 	tvdbIDInt, err := strconv.Atoi(tvdbID)
 	if err != nil {
@@ -48,7 +106,7 @@ func (c *Client) GetSeriesByTVDBID(tvdbID string) (*Series, error) {
 	}
 
 	// Get all series
-	allSeries, err := c.GetAllSeries()
+	allSeries, err := c.GetAllSeries(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -64,96 +122,236 @@ func (c *Client) GetSeriesByTVDBID(tvdbID string) (*Series, error) {
 }
 
 // GetAllSeries gets all series from Sonarr
-func (c *Client) GetAllSeries() ([]Series, error) {
+func (c *Client) GetAllSeries(ctx context.Context) ([]Series, error) {
 	endpoint := "/api/v3/series"
 	var series []Series
 
-	if err := c.get(endpoint, &series); err != nil {
+	if err := c.httpClient.Get(ctx, endpoint, &series); err != nil {
 		return nil, err
 	}
 
 	return series, nil
 }
 
-// DeleteSeries deletes a series from Sonarr
-func (c *Client) DeleteSeries(tvdbID string) error {
-	// First get the Sonarr series ID from TVDB ID
-	series, err := c.GetSeriesByTVDBID(tvdbID)
-	if err != nil {
-		return err
-	}
+// DeleteOptions controls what happens to a series's files when it is deleted
+type DeleteOptions struct {
+	DeleteFiles            bool // Delete the series files from disk
+	AddImportListExclusion bool // Prevent import lists from adding it again
+}
+
+// DeleteSeriesByID deletes a series from Sonarr by its Sonarr ID
+func (c *Client) DeleteSeriesByID(ctx context.Context, seriesID int, opts DeleteOptions) error {
+	endpoint := fmt.Sprintf("/api/v3/series/%d", seriesID)
 
-	// Delete the series
-	endpoint := fmt.Sprintf("/api/v3/series/%d", series.ID)
-	
 	// Add query parameters for deletion options
 	queryParams := url.Values{}
-	queryParams.Add("deleteFiles", "true")  // Delete the series files
-	queryParams.Add("addImportListExclusion", "false") // Don't add to import list exclusions
-	
+	queryParams.Add("deleteFiles", strconv.FormatBool(opts.DeleteFiles))
+	queryParams.Add("addImportListExclusion", strconv.FormatBool(opts.AddImportListExclusion))
+
 	endpoint = endpoint + "?" + queryParams.Encode()
-	
-	return c.delete(endpoint, nil)
+
+	return c.httpClient.Delete(ctx, endpoint, nil)
 }
 
-// HTTP helpers
-func (c *Client) get(endpoint string, response interface{}) error {
-	req, err := http.NewRequest("GET", c.baseURL+endpoint, nil)
-	if err != nil {
-		return err
+// AddSeriesOptions controls how a series is added to Sonarr
+type AddSeriesOptions struct {
+	QualityProfileID  int
+	LanguageProfileID int
+	RootFolderPath    string
+	Monitored         bool
+	SeasonFolder      bool
+	SeriesType        string
+	Tags              []int
+	Search            bool // Search for missing episodes once added
+}
+
+// AddSeries looks up a series by TVDB ID and adds it to Sonarr
+func (c *Client) AddSeries(ctx context.Context, tvdbID string, opts AddSeriesOptions) (*Series, error) {
+	endpoint := "/api/v3/series/lookup?term=" + url.QueryEscape("tvdb:"+tvdbID)
+	var results []map[string]interface{}
+
+	if err := c.httpClient.Get(ctx, endpoint, &results); err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("series with TVDB ID %s not found in lookup", tvdbID)
 	}
 
-	return c.doRequest(req, response)
+	// Start from the lookup result so that seasons and metadata are included
+	body := results[0]
+	body["qualityProfileId"] = opts.QualityProfileID
+	if opts.LanguageProfileID != 0 {
+		body["languageProfileId"] = opts.LanguageProfileID
+	}
+	body["rootFolderPath"] = opts.RootFolderPath
+	body["monitored"] = opts.Monitored
+	body["seasonFolder"] = opts.SeasonFolder
+	if opts.SeriesType != "" {
+		body["seriesType"] = opts.SeriesType
+	}
+	if opts.Tags != nil {
+		body["tags"] = opts.Tags
+	} else {
+		body["tags"] = []int{}
+	}
+	body["addOptions"] = map[string]interface{}{
+		"searchForMissingEpisodes": opts.Search,
+	}
+
+	var series Series
+	if err := c.httpClient.Post(ctx, "/api/v3/series", body, &series); err != nil {
+		return nil, err
+	}
+
+	return &series, nil
 }
 
-func (c *Client) post(endpoint string, body interface{}, response interface{}) error {
-	var bodyJSON []byte
-	var err error
-	
-	if body != nil {
-		bodyJSON, err = json.Marshal(body)
-		if err != nil {
-			return err
-		}
+// SearchSeason triggers a search for the missing episodes of a season
+func (c *Client) SearchSeason(ctx context.Context, seriesID, seasonNumber int) error {
+	body := map[string]interface{}{
+		"name":         "SeasonSearch",
+		"seriesId":     seriesID,
+		"seasonNumber": seasonNumber,
 	}
+	return c.httpClient.Post(ctx, "/api/v3/command", body, nil)
+}
 
-	req, err := http.NewRequest("POST", c.baseURL+endpoint, strings.NewReader(string(bodyJSON)))
-	if err != nil {
-		return err
+// RescanSeries triggers a rescan of a series' folder, picking up files that were added to it
+func (c *Client) RescanSeries(ctx context.Context, seriesID int) error {
+	body := map[string]interface{}{
+		"name":     "RescanSeries",
+		"seriesId": seriesID,
+	}
+	return c.httpClient.Post(ctx, "/api/v3/command", body, nil)
+}
+
+// GetEpisodeFiles gets all episode files of a series
+func (c *Client) GetEpisodeFiles(ctx context.Context, seriesID int) ([]EpisodeFile, error) {
+	endpoint := fmt.Sprintf("/api/v3/episodefile?seriesId=%d", seriesID)
+	var files []EpisodeFile
+
+	if err := c.httpClient.Get(ctx, endpoint, &files); err != nil {
+		return nil, err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	return c.doRequest(req, response)
+	return files, nil
 }
 
-func (c *Client) delete(endpoint string, response interface{}) error {
-	req, err := http.NewRequest("DELETE", c.baseURL+endpoint, nil)
-	if err != nil {
-		return err
+// GetEpisodes gets all episodes of a series
+func (c *Client) GetEpisodes(ctx context.Context, seriesID int) ([]Episode, error) {
+	endpoint := fmt.Sprintf("/api/v3/episode?seriesId=%d", seriesID)
+	var episodes []Episode
+
+	if err := c.httpClient.Get(ctx, endpoint, &episodes); err != nil {
+		return nil, err
 	}
 
-	return c.doRequest(req, response)
+	return episodes, nil
 }
 
-func (c *Client) doRequest(req *http.Request, response interface{}) error {
-	// Add API key to all requests
-	q := req.URL.Query()
-	q.Add("apikey", c.apiKey)
-	req.URL.RawQuery = q.Encode()
-	
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
+// DeleteEpisodeFile deletes an episode file from disk
+func (c *Client) DeleteEpisodeFile(ctx context.Context, episodeFileID int) error {
+	endpoint := fmt.Sprintf("/api/v3/episodefile/%d", episodeFileID)
+	return c.httpClient.Delete(ctx, endpoint, nil)
+}
+
+// SearchSeries triggers a search for all missing episodes of a series
+func (c *Client) SearchSeries(ctx context.Context, seriesID int) error {
+	body := map[string]interface{}{
+		"name":     "SeriesSearch",
+		"seriesId": seriesID,
+	}
+	return c.httpClient.Post(ctx, "/api/v3/command", body, nil)
+}
+
+// SetSeriesMonitored changes whether Sonarr monitors a series
+func (c *Client) SetSeriesMonitored(ctx context.Context, seriesID int, monitored bool) error {
+	return c.updateSeries(ctx, seriesID, func(series map[string]interface{}) error {
+		series["monitored"] = monitored
+		return nil
+	})
+}
+
+// SetSeasonMonitored changes whether Sonarr monitors a season of a series
+func (c *Client) SetSeasonMonitored(ctx context.Context, seriesID, seasonNumber int, monitored bool) error {
+	return c.updateSeries(ctx, seriesID, func(series map[string]interface{}) error {
+		seasons, ok := series["seasons"].([]interface{})
+		if !ok {
+			return fmt.Errorf("series %d has no seasons", seriesID)
+		}
+
+		for _, s := range seasons {
+			season, ok := s.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if number, ok := season["seasonNumber"].(float64); ok && int(number) == seasonNumber {
+				season["monitored"] = monitored
+				return nil
+			}
+		}
+
+		return fmt.Errorf("season %d not found in series %d", seasonNumber, seriesID)
+	})
+}
+
+// updateSeries fetches the full series resource, applies update and writes it back.
+// The raw resource is used so that fields this client doesn't model are preserved.
+func (c *Client) updateSeries(ctx context.Context, seriesID int, update func(series map[string]interface{}) error) error {
+	endpoint := fmt.Sprintf("/api/v3/series/%d", seriesID)
+	var series map[string]interface{}
+
+	if err := c.httpClient.Get(ctx, endpoint, &series); err != nil {
 		return err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("API request failed with status: %s", resp.Status)
+	if err := update(series); err != nil {
+		return err
 	}
 
-	if response != nil {
-		return json.NewDecoder(resp.Body).Decode(response)
+	return c.httpClient.Put(ctx, endpoint, series, nil)
+}
+
+// GetQualityProfiles gets all quality profiles from Sonarr
+func (c *Client) GetQualityProfiles(ctx context.Context) ([]QualityProfile, error) {
+	endpoint := "/api/v3/qualityprofile"
+	var profiles []QualityProfile
+
+	if err := c.httpClient.Get(ctx, endpoint, &profiles); err != nil {
+		return nil, err
 	}
 
-	return nil
-}
\ No newline at end of file
+	return profiles, nil
+}
+
+// SetSeriesQualityProfile switches a series to another quality profile
+func (c *Client) SetSeriesQualityProfile(ctx context.Context, seriesID, profileID int) error {
+	return c.updateSeries(ctx, seriesID, func(series map[string]interface{}) error {
+		series["qualityProfileId"] = profileID
+		return nil
+	})
+}
+
+// GetTags gets all tags from Sonarr
+func (c *Client) GetTags(ctx context.Context) ([]Tag, error) {
+	endpoint := "/api/v3/tag"
+	var tags []Tag
+
+	if err := c.httpClient.Get(ctx, endpoint, &tags); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// GetDiskSpace gets the free space of the disks Sonarr can see
+func (c *Client) GetDiskSpace(ctx context.Context) ([]DiskSpace, error) {
+	endpoint := "/api/v3/diskspace"
+	var disks []DiskSpace
+
+	if err := c.httpClient.Get(ctx, endpoint, &disks); err != nil {
+		return nil, err
+	}
+
+	return disks, nil
+}
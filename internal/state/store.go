@@ -0,0 +1,235 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Record describes an item that has been marked for deletion
+type Record struct {
+	ItemID     string     `json:"item_id"`
+	Name       string     `json:"name"`
+	Type       string     `json:"type"`                // "Series", "Movie" or "Season"
+	ExternalID string     `json:"external_id"`         // TVDB/TMDB ID; the series' TVDB ID for a season
+	SeriesID   string     `json:"series_id,omitempty"` // Jellyfin ID of a season's series
+	Season     int        `json:"season,omitempty"`    // Season number of a season
+	Library    string     `json:"library,omitempty"`
+	Rule       string     `json:"rule,omitempty"`
+	Reason     string     `json:"reason,omitempty"`
+	MarkedAt   time.Time  `json:"marked_at"`
+	DueAt      time.Time  `json:"due_at"`
+	Downgrade  *Downgrade `json:"downgrade,omitempty"` // Set once a downgrade of the item has started
+}
+
+// Downgrade tracks a title that was switched to a smaller quality profile and
+// is waiting for Sonarr/Radarr to replace its files
+type Downgrade struct {
+	QualityProfileID int         `json:"quality_profile_id"`         // Profile the title had before
+	FileIDs          []int       `json:"file_ids,omitempty"`         // Radarr movie files to replace
+	EpisodeFileIDs   map[int]int `json:"episode_file_ids,omitempty"` // Sonarr episode ID to the file to replace
+	SearchedAt       time.Time   `json:"searched_at"`                // When the smaller release was searched for
+}
+
+// Protection keeps an item from being marked until it expires
+type Protection struct {
+	ItemID string    `json:"item_id"` // Jellyfin item ID, or the TVDB/TMDB key of a restored title
+	Name   string    `json:"name"`
+	KeptBy string    `json:"kept_by,omitempty"` // Who voted to keep the item, or "restore"
+	Until  time.Time `json:"until"`
+}
+
+// Store persists marked items in a JSON file. It is the source of truth for
+// what is headed out; the Jellyfin playlist and tags only mirror it.
+type Store struct {
+	path     string
+	created  bool
+	inMemory bool // Changes are never saved
+
+	mu          sync.Mutex
+	records     map[string]Record
+	protections map[string]Protection
+}
+
+type storeFile struct {
+	Records     []Record     `json:"records"`
+	Protections []Protection `json:"protections,omitempty"`
+}
+
+// Open loads the store at path. A missing file yields an empty store that is
+// written on the first change.
+func Open(path string) (*Store, error) {
+	s := &Store{
+		path:        path,
+		records:     make(map[string]Record),
+		protections: make(map[string]Protection),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		s.created = true
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading state file: %w", err)
+	}
+
+	var file storeFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("error parsing state file: %w", err)
+	}
+	for _, record := range file.Records {
+		s.records[record.ItemID] = record
+	}
+	for _, protection := range file.Protections {
+		s.protections[protection.ItemID] = protection
+	}
+
+	return s, nil
+}
+
+// Created reports whether the store did not exist on disk when it was opened
+func (s *Store) Created() bool {
+	return s.created
+}
+
+// KeepInMemory stops the store from saving changes, so that a dry run can
+// work with the same records as a real run without keeping them
+func (s *Store) KeepInMemory() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.inMemory = true
+}
+
+// Get returns the record for an item
+func (s *Store) Get(itemID string) (Record, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[itemID]
+	return record, ok
+}
+
+// Records returns all records, ordered by due date
+func (s *Store) Records() []Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := make([]Record, 0, len(s.records))
+	for _, record := range s.records {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if !records[i].DueAt.Equal(records[j].DueAt) {
+			return records[i].DueAt.Before(records[j].DueAt)
+		}
+		return records[i].ItemID < records[j].ItemID
+	})
+	return records
+}
+
+// Put adds or replaces a record and saves the store
+func (s *Store) Put(record Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records[record.ItemID] = record
+	return s.save()
+}
+
+// Delete removes a record and saves the store
+func (s *Store) Delete(itemID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.records[itemID]; !ok {
+		return nil
+	}
+	delete(s.records, itemID)
+	return s.save()
+}
+
+// Protected returns the protection of an item if it hasn't expired yet
+func (s *Store) Protected(itemID string, now time.Time) (Protection, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	protection, ok := s.protections[itemID]
+	if !ok || !now.Before(protection.Until) {
+		return Protection{}, false
+	}
+	return protection, true
+}
+
+// Protect adds or replaces protections and saves the store once. Expired
+// protections are dropped at the same time.
+func (s *Store) Protect(protections ...Protection) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for itemID, p := range s.protections {
+		if !now.Before(p.Until) {
+			delete(s.protections, itemID)
+		}
+	}
+
+	for _, protection := range protections {
+		s.protections[protection.ItemID] = protection
+	}
+	return s.save()
+}
+
+// save writes the store to a temporary file and renames it into place, so
+// that a crash never leaves a truncated state file behind
+func (s *Store) save() error {
+	if s.inMemory {
+		return nil
+	}
+
+	file := storeFile{Records: make([]Record, 0, len(s.records))}
+	for _, record := range s.records {
+		file.Records = append(file.Records, record)
+	}
+	sort.Slice(file.Records, func(i, j int) bool {
+		return file.Records[i].ItemID < file.Records[j].ItemID
+	})
+	for _, protection := range s.protections {
+		file.Protections = append(file.Protections, protection)
+	}
+	sort.Slice(file.Protections, func(i, j int) bool {
+		return file.Protections[i].ItemID < file.Protections[j].ItemID
+	})
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("error writing state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing state file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("error writing state file: %w", err)
+	}
+
+	s.created = false
+	return nil
+}
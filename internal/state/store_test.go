@@ -0,0 +1,231 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testRecord(itemID string, dueAt time.Time) Record {
+	return Record{
+		ItemID:     itemID,
+		Name:       "Item " + itemID,
+		Type:       "Movie",
+		ExternalID: "603",
+		Library:    "Movies",
+		Rule:       "max_age_days(30)",
+		Reason:     "Exceeds maximum age",
+		MarkedAt:   dueAt.AddDate(0, 0, -14),
+		DueAt:      dueAt,
+	}
+}
+
+func TestOpenMissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if !s.Created() {
+		t.Error("Created() = false for a missing file")
+	}
+	if len(s.Records()) != 0 {
+		t.Errorf("got %d records, want none", len(s.Records()))
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("state file exists before the first change: %v", err)
+	}
+}
+
+func TestOpenInvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte("{not json"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Open(path); err == nil {
+		t.Error("Open succeeded on a corrupt state file")
+	}
+}
+
+func TestSaveAndReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	due := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := testRecord("m1", due)
+	if err := s.Put(record); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := s.Put(testRecord("m2", due)); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := s.Delete("m2"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	protection := Protection{ItemID: "m3", Name: "Item m3", KeptBy: "alice", Until: due}
+	if err := s.Protect(protection); err != nil {
+		t.Fatalf("Protect: %v", err)
+	}
+	if s.Created() {
+		t.Error("Created() = true after saving")
+	}
+
+	reloaded, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if reloaded.Created() {
+		t.Error("Created() = true for an existing file")
+	}
+
+	got, ok := reloaded.Get("m1")
+	if !ok {
+		t.Fatal("record m1 was not saved")
+	}
+	if got != record {
+		t.Errorf("reloaded record = %+v, want %+v", got, record)
+	}
+	if _, ok := reloaded.Get("m2"); ok {
+		t.Error("deleted record m2 was saved")
+	}
+	if p, ok := reloaded.Protected("m3", due.Add(-time.Hour)); !ok || p != protection {
+		t.Errorf("reloaded protection = %+v, %v, want %+v", p, ok, protection)
+	}
+}
+
+func TestKeepInMemory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.KeepInMemory()
+
+	if err := s.Put(testRecord("m1", time.Now())); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := s.Protect(Protection{ItemID: "m2", Until: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatalf("Protect: %v", err)
+	}
+	if _, ok := s.Get("m1"); !ok {
+		t.Error("record isn't kept in memory")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("an in-memory store wrote the state file: %v", err)
+	}
+}
+
+func TestRecordsOrder(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	early := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+	late := early.AddDate(0, 0, 7)
+	for _, record := range []Record{testRecord("c", late), testRecord("b", early), testRecord("a", late)} {
+		if err := s.Put(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var ids []string
+	for _, record := range s.Records() {
+		ids = append(ids, record.ItemID)
+	}
+	if got, want := len(ids), 3; got != want {
+		t.Fatalf("got %d records, want %d", got, want)
+	}
+	if ids[0] != "b" || ids[1] != "a" || ids[2] != "c" {
+		t.Errorf("records are ordered %v, want [b a c]", ids)
+	}
+}
+
+func TestDeleteMissingRecordDoesNotWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Delete("missing"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("deleting a missing record wrote the state file: %v", err)
+	}
+}
+
+func TestProtection(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	if err := s.Protect(Protection{ItemID: "old", Until: now.Add(-time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Protected("old", now); ok {
+		t.Error("expired protection still applies")
+	}
+
+	if err := s.Protect(Protection{ItemID: "new", Until: now.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Protected("new", now); !ok {
+		t.Error("protection doesn't apply before it expires")
+	}
+	if _, ok := s.Protected("new", now.Add(time.Hour)); ok {
+		t.Error("protection still applies when it expires")
+	}
+	if _, ok := s.protections["old"]; ok {
+		t.Error("expired protection wasn't dropped when protecting another item")
+	}
+}
+
+func TestSaveIsAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+	due := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(testRecord("m1", due)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path); err != nil {
+		t.Fatalf("saved state file can't be read back: %v", err)
+	}
+
+	// Point the store at a directory the temporary file can't be renamed over
+	blocked := filepath.Join(dir, "blocked.json")
+	if err := os.MkdirAll(filepath.Join(blocked, "child"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	s.path = blocked
+	if err := s.Put(testRecord("m2", due)); err == nil {
+		t.Fatal("Put succeeded although the state file can't be replaced")
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Name() != "state.json" && entry.Name() != "blocked.json" {
+			t.Errorf("temporary file %s was left behind", entry.Name())
+		}
+	}
+
+	if reloaded, err := Open(path); err != nil || len(reloaded.Records()) != 1 {
+		t.Errorf("a failed save changed the existing state file: %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/alex4108/jellycleaner/internal/jellyfin"
+	"github.com/alex4108/jellycleaner/internal/state"
+)
+
+// rescued reports whether a user voted to keep an item that would be marked
+// or deleted. The vote cancels the deletion and protects the item from being
+// marked again for keep_vote.protection_days, once saveKeptItems has run.
+func (c *cleaner) rescued(ctx context.Context, item jellyfin.Item) (string, bool) {
+	if !c.cfg.KeepVote.Enabled {
+		return "", false
+	}
+
+	keptBy := c.keptBy(ctx, item)
+	if keptBy == "" {
+		return "", false
+	}
+	log.Infof("Keeping %s, voted for by %s", item.Name, keptBy)
+
+	if c.plan != nil {
+		return keptBy, true
+	}
+
+	protection := state.Protection{
+		ItemID: item.ID,
+		Name:   item.Name,
+		KeptBy: keptBy,
+		Until:  time.Now().AddDate(0, 0, c.cfg.KeepVote.ProtectionDays),
+	}
+	c.keptItemsMu.Lock()
+	c.keptItems = append(c.keptItems, protection)
+	c.keptItemsMu.Unlock()
+	return keptBy, true
+}
+
+// saveKeptItems records the protections of the keep votes found since it last
+// ran in the state store, writing it once
+func (c *cleaner) saveKeptItems() {
+	c.keptItemsMu.Lock()
+	protections := c.keptItems
+	c.keptItems = nil
+	c.keptItemsMu.Unlock()
+
+	if len(protections) == 0 {
+		return
+	}
+	if err := c.store.Protect(protections...); err != nil {
+		log.Errorf("Failed to record %d keep votes in state store: %v", len(protections), err)
+	}
+}
+
+// protected reports whether an item is protected from being marked, by a keep
+// vote on it or by a restore of its title
+func (c *cleaner) protected(item jellyfin.Item) bool {
+	now := time.Now()
+	if _, ok := c.store.Protected(item.ID, now); ok {
+		return true
+	}
+	_, ok := c.store.Protected(mediaKey(item), now)
+	return ok
+}
+
+// keptBy returns the name of a user who voted to keep an item, or "" if nobody did
+func (c *cleaner) keptBy(ctx context.Context, item jellyfin.Item) string {
+	kv := c.cfg.KeepVote
+
+	if kv.Playlist != "" {
+		if user, ok := c.keepPlaylistItems(ctx)[item.ID]; ok {
+			return user
+		}
+	}
+
+	if kv.Favorites {
+		playStates, err := c.itemPlayStates(ctx, item)
+		if err != nil {
+			log.Warnf("Failed to check favorites of %s: %v", item.Name, err)
+			return ""
+		}
+		for _, playState := range playStates {
+			if playState.IsFavorite && !playState.User.IsDisabled {
+				return playState.User.Name
+			}
+		}
+	}
+
+	return ""
+}
+
+// keepPlaylistItems maps the items in every enabled user's keep playlist to
+// that user. The playlists are loaded once per run.
+func (c *cleaner) keepPlaylistItems(ctx context.Context) map[string]string {
+	c.keepPlaylistsMu.Lock()
+	defer c.keepPlaylistsMu.Unlock()
+
+	if c.keepPlaylists != nil {
+		return c.keepPlaylists
+	}
+	c.keepPlaylists = make(map[string]string)
+
+	users, err := c.jellyfinClient.GetUsers(ctx)
+	if err != nil {
+		log.Warnf("Failed to get Jellyfin users for keep playlists: %v", err)
+		return c.keepPlaylists
+	}
+
+	for _, user := range users {
+		if user.IsDisabled {
+			continue
+		}
+		itemIDs, err := c.jellyfinClient.GetUserPlaylistItemIDs(ctx, user.ID, c.cfg.KeepVote.Playlist)
+		if err != nil {
+			log.Warnf("Failed to get %s playlist of %s: %v", c.cfg.KeepVote.Playlist, user.Name, err)
+			continue
+		}
+		for _, itemID := range itemIDs {
+			if _, ok := c.keepPlaylists[itemID]; !ok {
+				c.keepPlaylists[itemID] = user.Name
+			}
+		}
+	}
+
+	return c.keepPlaylists
+}
@@ -1,21 +1,128 @@
 package main
 
 import (
-	"log"
+	"context"
+	"flag"
+	"fmt"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	log "github.com/sirupsen/logrus"
+
 	"github.com/alex4108/jellycleaner/config"
+	"github.com/alex4108/jellycleaner/internal/audit"
+	"github.com/alex4108/jellycleaner/internal/exclusions"
+	"github.com/alex4108/jellycleaner/internal/httpx"
 	"github.com/alex4108/jellycleaner/internal/jellyfin"
+	"github.com/alex4108/jellycleaner/internal/jellyseerr"
+	"github.com/alex4108/jellycleaner/internal/notify"
+	"github.com/alex4108/jellycleaner/internal/plan"
 	"github.com/alex4108/jellycleaner/internal/radarr"
+	"github.com/alex4108/jellycleaner/internal/rules"
 	"github.com/alex4108/jellycleaner/internal/sonarr"
+	"github.com/alex4108/jellycleaner/internal/state"
 )
 
-const ( 
+const (
 	expireTagConst = "Jellycleaner-Expire-"
 )
 
+// cleaner bundles the configuration and service clients used during a run
+type cleaner struct {
+	cfg              *config.Config
+	jellyfinClient   *jellyfin.Client
+	sonarrClient     *sonarr.Client
+	radarrClient     *radarr.Client
+	jellyseerrClient *jellyseerr.Client
+
+	// plan is non-nil in dry-run mode; actions are recorded here instead of executed
+	plan *plan.Plan
+
+	// store holds the items marked for deletion
+	store *state.Store
+
+	// auditLog records every deletion
+	auditLog *audit.Log
+
+	// playStates is every user's playback information for the library items
+	// and the seasons of season cleanup libraries, keyed by item ID. It is
+	// loaded in bulk before items are evaluated and only read afterwards.
+	playStates map[string][]jellyfin.UserPlayState
+
+	// headedOut caches which items are in the "Headed Out" playlist, see inHeadedOut
+	headedOutMu sync.Mutex
+	headedOut   map[string]bool
+
+	// The caches below are filled on first use and shared by the workers
+	// evaluating items, so each is guarded by its own mutex
+
+	// requestIndex caches the Jellyseerr requests for the duration of a run.
+	// A failure to load them is cached too, so that an outage fails every
+	// lookup at once instead of retrying for each item.
+	requestsMu     sync.Mutex
+	requestsLoaded bool
+	requestIndex   *jellyseerr.RequestIndex
+	requestsErr    error
+
+	// arrTitles caches the Sonarr series and Radarr movies, keyed by mediaKey.
+	// arrTitlesErr holds the error of a service whose titles couldn't be
+	// loaded, keyed by item type.
+	arrTitlesMu  sync.Mutex
+	arrTitles    map[string]arrTitle
+	arrTitlesErr map[string]error
+
+	// arrTagLabels caches Sonarr/Radarr tag labels, keyed by item type and tag ID.
+	// arrTagLabelsErr holds the error of a service whose tags couldn't be
+	// loaded, keyed by item type, so that an outage isn't retried for each item.
+	arrTagLabelsMu  sync.Mutex
+	arrTagLabels    map[string]string
+	arrTagLabelsErr map[string]error
+
+	// qualityProfiles caches quality profile IDs, keyed by item type and profile name
+	qualityProfilesMu sync.Mutex
+	qualityProfiles   map[string]int
+
+	// keepPlaylists maps the items in users' keep playlists to the user, see keepPlaylistItems
+	keepPlaylistsMu sync.Mutex
+	keepPlaylists   map[string]string
+
+	// keptItems collects the protections of keep votes until they are saved, see rescued
+	keptItemsMu sync.Mutex
+	keptItems   []state.Protection
+
+	// digest collects what a run did, to be sent to the notifiers once it ends
+	digest    *notify.Digest
+	notifiers []notify.Notifier
+}
+
+// runOptions holds the settings given on the command line
+type runOptions struct {
+	dryRun   bool
+	planPath string
+}
+
 func main() {
+	// SIGINT/SIGTERM cancel the running cycle
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		if err := runRestore(ctx, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	dryRun := flag.Bool("dry-run", false, "Report planned actions without changing anything")
+	planPath := flag.String("plan", "", "Write the dry-run plan to this file instead of stdout")
+	daemon := flag.Bool("daemon", false, "Keep running and repeat every headed_out_playlist.check_interval_hours")
+	flag.Parse()
+
 	log.Info("Starting jellycleaner")
 
 	// Load configuration
@@ -25,200 +132,1093 @@ func main() {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
-	// Initialize clients
-	jellyfinClient, err := jellyfin.NewClient(cfg.Jellyfin.URL, os.Getenv("JELLYFIN_API_KEY"))
+	opts := runOptions{dryRun: *dryRun, planPath: *planPath}
+	if *daemon || cfg.Daemon {
+		log.Info("Daemon mode enabled")
+		runDaemon(ctx, configPath, cfg, opts)
+		return
+	}
+
+	if err := runCycle(ctx, cfg, opts); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Info("Job completed!")
+}
+
+// httpOptions converts a service's http settings into client options
+func httpOptions(cfg config.HTTPConfig) httpx.Options {
+	return httpx.Options{
+		Timeout:    time.Duration(cfg.TimeoutSeconds) * time.Second,
+		MaxRetries: cfg.MaxRetries,
+		RateLimit:  cfg.RateLimit,
+	}
+}
+
+// newCleaner initializes the service clients for cfg
+func newCleaner(cfg *config.Config) (*cleaner, error) {
+	jellyfinClient, err := jellyfin.NewClient(cfg.Jellyfin.URL, os.Getenv("JELLYFIN_API_KEY"), httpOptions(cfg.Jellyfin.HTTP))
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize Jellyfin client: %w", err)
+	}
+
+	sonarrClient, err := sonarr.NewClient(cfg.Sonarr.URL, os.Getenv("SONARR_API_KEY"), httpOptions(cfg.Sonarr.HTTP))
 	if err != nil {
-		log.Fatalf("Failed to initialize Jellyfin client: %v", err)
+		return nil, fmt.Errorf("failed to initialize Sonarr client: %w", err)
 	}
 
-	sonarrClient, err := sonarr.NewClient(cfg.Sonarr.URL, os.Getenv("SONARR_API_KEY"))
+	radarrClient, err := radarr.NewClient(cfg.Radarr.URL, os.Getenv("RADARR_API_KEY"), httpOptions(cfg.Radarr.HTTP))
 	if err != nil {
-		log.Fatalf("Failed to initialize Sonarr client: %v", err)
+		return nil, fmt.Errorf("failed to initialize Radarr client: %w", err)
 	}
 
-	radarrClient, err := radarr.NewClient(cfg.Radarr.URL, os.Getenv("RADARR_API_KEY"))
+	jellyseerrClient, err := jellyseerr.NewClient(cfg.Jellyseerr.URL, os.Getenv("JELLYSEERR_API_KEY"), httpOptions(cfg.Jellyseerr.HTTP))
 	if err != nil {
-		log.Fatalf("Failed to initialize Radarr client: %v", err)
+		return nil, fmt.Errorf("failed to initialize Jellyseerr client: %w", err)
+	}
+
+	return &cleaner{
+		cfg:              cfg,
+		jellyfinClient:   jellyfinClient,
+		sonarrClient:     sonarrClient,
+		radarrClient:     radarrClient,
+		jellyseerrClient: jellyseerrClient,
+	}, nil
+}
+
+// runCycle performs the given phases, or a full evaluation and deletion pass if none are given.
+// Cancelling ctx, or exceeding run_timeout_minutes, stops the cycle after the item in progress.
+func runCycle(ctx context.Context, cfg *config.Config, opts runOptions, phases ...string) error {
+	if cfg.RunTimeoutMinutes > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(cfg.RunTimeoutMinutes)*time.Minute)
+		defer cancel()
 	}
 
-	jellyseerrClient, err := jellyseerr.NewClient(cfg.Jellyseerr.URL, os.Getenv("JELLYSEERR_API_KEY"))
+	c, err := newCleaner(cfg)
 	if err != nil {
-		log.Fatalf("Failed to initialize Jellyseerr client: %v", err)
+		return err
 	}
 
-	
-	processContent(cfg, jellyfinClient, sonarrClient, radarrClient, jellyseerrClient)
+	if opts.dryRun || cfg.DryRun {
+		log.Info("Dry-run mode enabled, no changes will be made")
+		c.plan = plan.New()
+	}
 
-	log.Info("Job completed!")
+	c.auditLog = audit.Open(cfg.Audit.Path)
+
+	c.store, err = state.Open(cfg.State.Path)
+	if err != nil {
+		return fmt.Errorf("failed to open state store: %w", err)
+	}
+	if c.plan != nil {
+		// A dry run sees the same records as a real run, but never saves them
+		c.store.KeepInMemory()
+	}
+	if c.store.Created() {
+		if c.plan == nil {
+			log.Infof("Creating state store at %s", cfg.State.Path)
+		}
+		if err := c.importFromJellyfin(ctx); err != nil {
+			return fmt.Errorf("failed to import marked items from Jellyfin: %w", err)
+		}
+	}
+
+	if c.plan == nil {
+		c.digest = &notify.Digest{}
+		c.notifiers = notify.New(cfg.Notifications)
+	}
+
+	if len(phases) == 0 {
+		c.processContent(ctx)
+	}
+	for _, phase := range phases {
+		switch phase {
+		case config.PhaseMark:
+			c.markContent(ctx)
+		case config.PhaseDelete:
+			c.processItemsDueForDeletion(ctx)
+		}
+	}
+
+	// Report what was done even if the cycle was interrupted
+	notifyCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	c.sendDigest(notifyCtx)
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("cycle interrupted: %w", err)
+	}
+
+	if c.plan != nil {
+		if err := writePlan(c.plan, opts.planPath); err != nil {
+			return fmt.Errorf("failed to write plan: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// markedItem describes a newly marked record for the digest, including who requested it
+func (c *cleaner) markedItem(ctx context.Context, record state.Record) notify.Item {
+	item := notify.Item{
+		Name:           record.Name,
+		Type:           record.Type,
+		Library:        record.Library,
+		Reason:         record.Reason,
+		ExpirationDate: record.DueAt.Format("2006-01-02"),
+	}
+
+	requests, err := c.requests(ctx)
+	if err != nil {
+		log.Warnf("Failed to get Jellyseerr requests for notifications: %v", err)
+		return item
+	}
+	if request := requests.Find(jellyseerrMediaType(record.Type), record.ExternalID); request != nil {
+		item.RequestedBy = request.RequestedBy.Name
+		item.RequestedByEmail = request.RequestedBy.Email
+	}
+	return item
 }
 
-func processContent(cfg *config.Config, jellyfinClient *jellyfin.Client, sonarrClient *sonarr.Client, radarrClient *radarr.Client) {
+// sendDigest sends what the run did to every configured notifier
+func (c *cleaner) sendDigest(ctx context.Context) {
+	if c.digest == nil || c.digest.Empty() {
+		return
+	}
+
+	for _, notifier := range c.notifiers {
+		if err := notifier.Notify(ctx, c.digest); err != nil {
+			log.Errorf("Failed to send %s notification: %v", notifier.Name(), err)
+		}
+	}
+}
+
+func (c *cleaner) processContent(ctx context.Context) {
+	c.markContent(ctx)
+
+	// Process items that are due for deletion
+	c.processItemsDueForDeletion(ctx)
+}
+
+// markContent evaluates every library and marks or unmarks items for deletion
+func (c *cleaner) markContent(ctx context.Context) {
 	log.Info("Starting content evaluation process...")
 
+	sources := c.sources()
+
+	users, err := c.jellyfinClient.GetUsers(ctx)
+	if err != nil {
+		log.Errorf("Failed to get Jellyfin users: %v", err)
+		return
+	}
+
+	// Get all items in each library, with every user's play states, up front
+	// so that rules work from this snapshot instead of requesting each item
+	libraryItems := make(map[string][]jellyfin.Item)
+	c.playStates = make(map[string][]jellyfin.UserPlayState)
+	for _, library := range c.cfg.Jellyfin.Libraries {
+		items, err := c.jellyfinClient.GetLibraryItems(ctx, library.Name)
+		if err != nil {
+			log.Infof("Error getting library items for %s: %v", library.Name, err)
+			continue
+		}
+		playStates, err := c.jellyfinClient.GetLibraryPlayStates(ctx, library.Name, users)
+		if err != nil {
+			log.Infof("Error getting play states for %s: %v", library.Name, err)
+			continue
+		}
+		if library.SeasonCleanup.Enabled {
+			seasonPlayStates, err := c.jellyfinClient.GetLibrarySeasonPlayStates(ctx, library.Name, users)
+			if err != nil {
+				log.Infof("Error getting season play states for %s: %v", library.Name, err)
+				continue
+			}
+			for seasonID, states := range seasonPlayStates {
+				playStates[seasonID] = states
+			}
+		}
+		libraryItems[library.Name] = items
+		for itemID, states := range playStates {
+			c.playStates[itemID] = states
+		}
+	}
+
+	// Select extra items to mark if the disk is running out of space
+	pressureMarks := c.diskPressureCandidates(ctx, libraryItems)
+
 	// Process each library
-	for _, library := range cfg.Jellyfin.Libraries {
+	for _, library := range c.cfg.Jellyfin.Libraries {
+		items, ok := libraryItems[library.Name]
+		if !ok {
+			continue
+		}
 		log.Infof("Processing library: %s", library.Name)
 
-		// Get all items in the library
-		items, err := jellyfinClient.GetLibraryItems(library.Name)
+		rule, err := rules.Build(library.Rules)
+		if err == nil {
+			err = rules.CheckUsers(rule, users)
+		}
 		if err != nil {
-			log.Infof("Error getting library items for %s: %v", library.Name, err)
+			log.Errorf("Invalid rules for library %s: %v", library.Name, err)
 			continue
 		}
 
-		// Process each item
-		for _, item := range items {
-			// Skip if the item is in the exclusion list
-			if isExcluded(item.Name, library.Exclusions) {
-				log.Infof("Skipping excluded item: %s", item.Name)
+		excluder, err := exclusions.Compile(library.Exclusions)
+		if err != nil {
+			log.Errorf("Invalid exclusions for library %s: %v", library.Name, err)
+			continue
+		}
+
+		// With season cleanup, the watched seasons of matching series are marked instead
+		var seasonRule *rules.WatchedBy
+		if library.SeasonCleanup.Enabled {
+			watchedRule := seasonCleanupRule(library)
+			if err := watchedRule.CheckUsers(users); err != nil {
+				log.Errorf("Invalid season_cleanup for library %s: %v", library.Name, err)
+				continue
+			}
+			seasonRule = &watchedRule
+		}
+
+		// Evaluate the items concurrently, then act on the results in library order
+		evaluations := make([]evaluation, len(items))
+		c.forEach(ctx, len(items), func(i int) {
+			evaluations[i] = c.evaluate(ctx, items[i], library, rule, seasonRule, excluder, sources, pressureMarks)
+		})
+		c.saveKeptItems()
+		// An interrupted evaluation can't be trusted, it would unmark items
+		if ctx.Err() != nil {
+			return
+		}
+
+		for i, item := range items {
+			ev := evaluations[i]
+			if ev.skip {
+				continue
+			}
+			if ev.exclusion != "" {
+				log.Infof("Skipping excluded item: %s (Exclusion: %s)", item.Name, ev.exclusion)
+			}
+			if seasonRule != nil && item.Type == "Series" {
+				c.markSeasons(ctx, item, library, ev)
 				continue
 			}
 
-			// Check if item should be marked for deletion
-			shouldDelete, reason := shouldMarkForDeletion(item, library, jellyfinClient)
-			if shouldDelete {
-				log.Infof("Marking item for deletion: %s (Reason: %s)", item.Name, reason)
-				
-				// Add to "Headed Out" playlist if not already there
-				if !jellyfinClient.IsInPlaylist(item.ID, cfg.HeadedOutPlaylist.Name) {
-					expirationDate := time.Now().AddDate(0, 0, cfg.HeadedOutPlaylist.DeletionDelayDays)
-					if err := jellyfinClient.AddToPlaylist(item.ID, cfg.HeadedOutPlaylist.Name); err != nil {
-						log.Infof("Failed to add %s to playlist: %v", item.Name, err)
-					} else {
-						// Add expiration tag
-						tag := formatExpirationTag(expirationDate)
-						if err := jellyfinClient.AddTag(item.ID, tag); err != nil {
-							log.Infof("Failed to add expiration tag to %s: %v", item.Name, err)
-						}
+			result := ev.result
+			if result.Matched {
+				log.Infof("Marking item for deletion: %s (Reason: %s)", item.Name, result.Reason)
+
+				// Record the item in the state store if it isn't marked yet
+				record, marked := c.store.Get(item.ID)
+				if !marked {
+					now := time.Now()
+					record = state.Record{
+						ItemID:     item.ID,
+						Name:       item.Name,
+						Type:       item.Type,
+						ExternalID: item.ExternalID,
+						Library:    library.Name,
+						Rule:       result.Rule,
+						Reason:     result.Reason,
+						MarkedAt:   now,
+						DueAt:      now.AddDate(0, 0, c.cfg.HeadedOutPlaylist.DeletionDelayDays),
 					}
-				}
-			} else {
-				// If item is in playlist but shouldn't be, remove it
-				if jellyfinClient.IsInPlaylist(item.ID, cfg.HeadedOutPlaylist.Name) {
-					log.Infof("Removing item from deletion list: %s", item.Name)
-					if err := jellyfinClient.RemoveFromPlaylist(item.ID, cfg.HeadedOutPlaylist.Name); err != nil {
-						log.Infof("Failed to remove %s from playlist: %v", item.Name, err)
+					if c.plan != nil {
+						c.plan.Add(plan.Entry{
+							ItemID:         item.ID,
+							Item:           item.Name,
+							Type:           item.Type,
+							Library:        library.Name,
+							Rule:           result.Rule,
+							Reason:         result.Reason,
+							Action:         plan.ActionMark,
+							ExpirationDate: record.DueAt.Format("2006-01-02"),
+						})
+						continue
 					}
-					// Remove expiration tag
-					expirationTags := jellyfinClient.GetExpirationTags(item.ID)
-					for _, tag := range expirationTags {
-						if err := jellyfinClient.RemoveTag(item.ID, tag); err != nil {
-							log.Infof("Failed to remove expiration tag from %s: %v", item.Name, err)
-						}
+					if err := c.store.Put(record); err != nil {
+						log.Errorf("Failed to record %s in state store: %v", item.Name, err)
+						continue
 					}
+					c.digest.Marked = append(c.digest.Marked, c.markedItem(ctx, record))
+				}
+				if c.plan != nil {
+					continue
+				}
+
+				// Make sure the "Headed Out" playlist and expiration tag reflect the record
+				c.mirrorRecord(ctx, record, item)
+			} else {
+				// If item is marked but shouldn't be, remove it
+				if ev.listed {
+					c.unmark(ctx, item, library.Name, result.Reason)
 				}
 			}
 		}
 	}
+}
 
-	// Process items that are due for deletion
-	processItemsDueForDeletion(cfg, jellyfinClient, sonarrClient, radarrClient)
+// unmark removes an item from the deletion list
+func (c *cleaner) unmark(ctx context.Context, item jellyfin.Item, library, reason string) {
+	log.Infof("Removing item from deletion list: %s", item.Name)
+	if c.plan != nil {
+		c.plan.Add(plan.Entry{
+			ItemID:  item.ID,
+			Item:    item.Name,
+			Type:    item.Type,
+			Library: library,
+			Reason:  reason,
+			Action:  plan.ActionUnmark,
+		})
+		return
+	}
+	if err := c.store.Delete(item.ID); err != nil {
+		log.Errorf("Failed to remove %s from state store: %v", item.Name, err)
+		return
+	}
+	c.clearMirror(ctx, item)
 }
 
-func isExcluded(itemName string, exclusions []string) bool {
-	for _, exclusion := range exclusions {
-		if itemName == exclusion {
-			return true
+// markSeasons marks the watched seasons selected for a series in a season
+// cleanup library and unmarks its other seasons. The series itself is never
+// marked there, so a series record left from an earlier version is dropped.
+func (c *cleaner) markSeasons(ctx context.Context, item jellyfin.Item, library config.Library, ev evaluation) {
+	if _, marked := c.store.Get(item.ID); marked || c.inHeadedOut(ctx, item.ID) {
+		c.unmark(ctx, item, library.Name, "Season cleanup marks seasons instead")
+	}
+
+	selected := make(map[string]bool, len(ev.seasons))
+	for _, season := range ev.seasons {
+		selected[season.ID] = true
+		if _, marked := c.store.Get(season.ID); marked {
+			continue
+		}
+
+		name := item.Name + " - " + season.Name
+		log.Infof("Marking season for deletion: %s (Reason: %s)", name, ev.result.Reason)
+		now := time.Now()
+		record := state.Record{
+			ItemID:     season.ID,
+			Name:       name,
+			Type:       "Season",
+			ExternalID: item.ExternalID,
+			SeriesID:   item.ID,
+			Season:     season.Number,
+			Library:    library.Name,
+			Rule:       ev.result.Rule,
+			Reason:     ev.result.Reason,
+			MarkedAt:   now,
+			DueAt:      now.AddDate(0, 0, c.cfg.HeadedOutPlaylist.DeletionDelayDays),
+		}
+		if c.plan != nil {
+			c.plan.Add(plan.Entry{
+				ItemID:         season.ID,
+				Item:           name,
+				Type:           "Season",
+				Library:        library.Name,
+				Rule:           ev.result.Rule,
+				Reason:         ev.result.Reason,
+				Action:         plan.ActionMark,
+				ExpirationDate: record.DueAt.Format("2006-01-02"),
+			})
+			continue
+		}
+		if err := c.store.Put(record); err != nil {
+			log.Errorf("Failed to record %s in state store: %v", name, err)
+			continue
+		}
+		c.digest.Marked = append(c.digest.Marked, c.markedItem(ctx, record))
+	}
+
+	reason := ev.result.Reason
+	if ev.result.Matched {
+		reason = "Season is no longer selected for cleanup"
+	}
+	for _, record := range c.store.Records() {
+		if record.Type == "Season" && record.SeriesID == item.ID && !selected[record.ItemID] {
+			c.unmark(ctx, recordItem(record), library.Name, reason)
+		}
+	}
+}
+
+// evaluation is the outcome of checking a single item against its library's
+// exclusions and rules
+type evaluation struct {
+	skip      bool              // The item couldn't be checked and is left as it is
+	exclusion string            // The exclusion matching the item, if any
+	result    rules.Result      // Matched if the item should be marked
+	listed    bool              // The item is marked or in the "Headed Out" playlist, for unmatched items
+	seasons   []jellyfin.Season // Watched seasons to mark, for matched series in season cleanup libraries
+}
+
+// evaluate decides whether an item should be marked. It only reads state, apart
+// from collecting keep votes, so that items can be evaluated concurrently.
+func (c *cleaner) evaluate(ctx context.Context, item jellyfin.Item, library config.Library, rule rules.Rule, seasonRule *rules.WatchedBy, excluder *exclusions.Matcher, sources rules.Sources, pressureMarks map[string]rules.Result) evaluation {
+	var ev evaluation
+
+	// A started downgrade is finished by processItemsDueForDeletion, whatever the rules say now
+	if record, ok := c.store.Get(item.ID); ok && record.Downgrade != nil {
+		return evaluation{skip: true}
+	}
+
+	// Excluded items are never marked, and unmarked if they already are
+	exclusion, err := excluder.Match(ctx, item, c.exclusionSources())
+	if err != nil {
+		log.Warnf("Skipping %s, failed to check exclusions: %v", item.Name, err)
+		return evaluation{skip: true}
+	}
+
+	if exclusion != "" {
+		ev.exclusion = exclusion
+		ev.result.Reason = "Excluded by " + exclusion
+	} else {
+		// Check if item should be marked for deletion, unless it is protected
+		// by a keep vote or the library's action has already been applied to it
+		protected := c.protected(item)
+		done, err := c.actionDone(ctx, item, library)
+		if err != nil {
+			log.Warnf("Skipping %s, failed to check its library action: %v", item.Name, err)
+			return evaluation{skip: true}
+		}
+		if !protected && !done {
+			result, err := shouldMarkForDeletion(ctx, item, rule, sources)
+			if err != nil {
+				// A failed lookup says nothing about the item, it mustn't unmark it
+				log.Warnf("Skipping %s, failed to evaluate rules: %v", item.Name, err)
+				return evaluation{skip: true}
+			}
+			ev.result = result
+		}
+		if pressureResult, ok := pressureMarks[item.ID]; ok && !ev.result.Matched {
+			ev.result = pressureResult
+		}
+		if ev.result.Matched {
+			if keptBy, ok := c.rescued(ctx, item); ok {
+				ev.result = rules.Result{Reason: "Kept by " + keptBy}
+			}
+		}
+	}
+
+	if seasonRule != nil && item.Type == "Series" {
+		if ev.result.Matched {
+			seasons, err := c.watchedSeasons(ctx, item, *seasonRule)
+			if err != nil {
+				log.Warnf("Skipping %s, failed to check its seasons: %v", item.Name, err)
+				return evaluation{skip: true}
+			}
+			ev.seasons = seasons
+		}
+		return ev
+	}
+
+	if !ev.result.Matched {
+		_, marked := c.store.Get(item.ID)
+		ev.listed = marked || c.inHeadedOut(ctx, item.ID)
+	}
+	return ev
+}
+
+// watchedSeasons returns the seasons of a series that rule matches and that
+// still have files in Sonarr, so that cleaned up seasons aren't marked again.
+// A series that isn't in Sonarr has none; failing to ask Sonarr is an error.
+func (c *cleaner) watchedSeasons(ctx context.Context, item jellyfin.Item, rule rules.WatchedBy) ([]jellyfin.Season, error) {
+	title, ok, err := c.arrTitle(ctx, item)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	seasons, err := c.jellyfinClient.GetSeasons(ctx, item.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var watched []jellyfin.Season
+	for _, season := range seasons {
+		if title.seasonFiles[season.Number] == 0 {
+			continue
+		}
+		if _, protected := c.store.Protected(seasonKey(item.ExternalID, season.Number), time.Now()); protected {
+			continue
+		}
+		seasonItem := jellyfin.Item{ID: season.ID, Name: item.Name + " - " + season.Name, Type: "Season"}
+		result, err := rule.Evaluate(ctx, seasonItem, rules.NewMetadata(c.sources(), seasonItem))
+		if err != nil {
+			return nil, err
+		}
+		if result.Matched {
+			watched = append(watched, season)
 		}
 	}
-	return false
+	return watched, nil
 }
 
-func shouldMarkForDeletion(item jellyfin.Item, library config.Library, jellyfinClient *jellyfin.Client) (bool, string) {
-	// Check if the item has been watched by all users
-	if library.Rules.DeleteIfWatchedByAll {
-		watchedByAll, err := jellyfinClient.IsWatchedByAllUsers(item.ID)
+// seasonCleanupRule returns the rule deciding which seasons of a series are watched
+func seasonCleanupRule(library config.Library) rules.WatchedBy {
+	wb := library.SeasonCleanup.WatchedBy
+	if wb == nil {
+		return rules.WatchedBy{}
+	}
+	return rules.WatchedBy{
+		Users:           wb.Users,
+		ExcludeUsers:    wb.ExcludeUsers,
+		MinUsers:        wb.MinUsers,
+		IncludeDisabled: wb.IncludeDisabled,
+	}
+}
+
+// exclusionSources returns where tag exclusions look up an item's tags
+func (c *cleaner) exclusionSources() exclusions.Sources {
+	return exclusions.Sources{
+		Tags: func(ctx context.Context, item jellyfin.Item) ([]string, error) {
+			return item.Tags, nil
+		},
+		ArrTags: c.arrTags,
+	}
+}
+
+// arrTags returns the labels of the Sonarr/Radarr tags of an item
+func (c *cleaner) arrTags(ctx context.Context, item jellyfin.Item) ([]string, error) {
+	title, ok, err := c.arrTitle(ctx, item)
+	if err != nil {
+		return nil, err
+	}
+	if !ok || len(title.tags) == 0 {
+		return nil, nil
+	}
+
+	c.arrTagLabelsMu.Lock()
+	defer c.arrTagLabelsMu.Unlock()
+
+	if c.arrTagLabels == nil {
+		c.arrTagLabels = make(map[string]string)
+		c.arrTagLabelsErr = make(map[string]error)
+
+		sonarrTags, err := c.sonarrClient.GetTags(ctx)
+		if err != nil {
+			c.arrTagLabelsErr["Series"] = fmt.Errorf("failed to get tags from Sonarr: %w", err)
+		}
+		for _, tag := range sonarrTags {
+			c.arrTagLabels["Series:"+strconv.Itoa(tag.ID)] = tag.Label
+		}
+
+		radarrTags, err := c.radarrClient.GetTags(ctx)
 		if err != nil {
-			log.Infof("Error checking if %s is watched by all: %v", item.Name, err)
-		} else if watchedByAll {
-			return true, "Watched by all users"
+			c.arrTagLabelsErr["Movie"] = fmt.Errorf("failed to get tags from Radarr: %w", err)
+		}
+		for _, tag := range radarrTags {
+			c.arrTagLabels["Movie:"+strconv.Itoa(tag.ID)] = tag.Label
 		}
 	}
+	if err := c.arrTagLabelsErr[item.Type]; err != nil {
+		return nil, err
+	}
+
+	labels := make([]string, 0, len(title.tags))
+	for _, id := range title.tags {
+		labels = append(labels, c.arrTagLabels[item.Type+":"+strconv.Itoa(id)])
+	}
+	return labels, nil
+}
+
+func shouldMarkForDeletion(ctx context.Context, item jellyfin.Item, rule rules.Rule, sources rules.Sources) (rules.Result, error) {
+	if rule == nil {
+		return rules.Result{}, nil
+	}
 
-	// Check if the item is older than the max age
-	if library.Rules.MaxAgeDays > 0 {
-		addedDate, err := jellyfinClient.GetItemAddedDate(item.ID)
+	return rule.Evaluate(ctx, item, rules.NewMetadata(sources, item))
+}
+
+// sources returns the services rule metadata is fetched from
+func (c *cleaner) sources() rules.Sources {
+	return rules.Sources{
+		PlayStates: c.itemPlayStates,
+		Requests:   c.requests,
+		UserMap:    c.cfg.Jellyseerr.UserMap,
+	}
+}
+
+// itemPlayStates returns every user's playback information for an item, from
+// the snapshot if the item is in it
+func (c *cleaner) itemPlayStates(ctx context.Context, item jellyfin.Item) ([]jellyfin.UserPlayState, error) {
+	if playStates, ok := c.playStates[item.ID]; ok {
+		return playStates, nil
+	}
+	return c.jellyfinClient.GetUserPlayStates(ctx, item.ID)
+}
+
+// requests loads the Jellyseerr requests once per run
+func (c *cleaner) requests(ctx context.Context) (*jellyseerr.RequestIndex, error) {
+	c.requestsMu.Lock()
+	defer c.requestsMu.Unlock()
+
+	if !c.requestsLoaded && c.requestIndex == nil {
+		c.requestsLoaded = true
+		requests, err := c.jellyseerrClient.GetAllRequests(ctx)
 		if err != nil {
-			log.Infof("Error getting added date for %s: %v", item.Name, err)
+			c.requestsErr = err
 		} else {
-			ageInDays := int(time.Since(addedDate).Hours() / 24)
-			if ageInDays > library.Rules.MaxAgeDays {
-				return true, "Exceeds maximum age"
+			c.requestIndex = jellyseerr.NewRequestIndex(requests)
+		}
+	}
+	return c.requestIndex, c.requestsErr
+}
+
+// arrTitle is the part of a Sonarr series or Radarr movie that library actions and exclusions use
+type arrTitle struct {
+	monitored        bool
+	qualityProfileID int
+	tags             []int
+	seasonFiles      map[int]int // Number of episode files in each season of a series
+}
+
+// actionDone reports whether the library's action has already been applied to
+// an item that is still in Jellyfin, so that it isn't marked again
+func (c *cleaner) actionDone(ctx context.Context, item jellyfin.Item, library config.Library) (bool, error) {
+	switch library.Action {
+	case config.ActionUnmonitor:
+		title, ok, err := c.arrTitle(ctx, item)
+		return ok && !title.monitored, err
+	case config.ActionDowngrade:
+		title, ok, err := c.arrTitle(ctx, item)
+		if err != nil || !ok {
+			return false, err
+		}
+		profileID, err := c.qualityProfileID(ctx, item.Type, library.Downgrade.QualityProfile)
+		if err != nil {
+			return false, fmt.Errorf("failed to look up quality profile for %s: %w", library.Name, err)
+		}
+		return title.qualityProfileID == profileID, nil
+	}
+	return false, nil
+}
+
+// arrTitle returns the Sonarr series or Radarr movie of an item, and whether
+// it is in Sonarr/Radarr at all. Every title is loaded once per run; an error
+// means the item's service couldn't be asked.
+func (c *cleaner) arrTitle(ctx context.Context, item jellyfin.Item) (arrTitle, bool, error) {
+	c.arrTitlesMu.Lock()
+	defer c.arrTitlesMu.Unlock()
+
+	if c.arrTitles == nil {
+		c.arrTitles = make(map[string]arrTitle)
+		c.arrTitlesErr = make(map[string]error)
+
+		allSeries, err := c.sonarrClient.GetAllSeries(ctx)
+		if err != nil {
+			c.arrTitlesErr["Series"] = fmt.Errorf("failed to get series from Sonarr: %w", err)
+		}
+		for _, series := range allSeries {
+			seasonFiles := make(map[int]int, len(series.Seasons))
+			for _, season := range series.Seasons {
+				seasonFiles[season.SeasonNumber] = season.Statistics.EpisodeFileCount
+			}
+			c.arrTitles["Series:"+strconv.Itoa(series.TVDBID)] = arrTitle{
+				monitored:        series.Monitored,
+				qualityProfileID: series.QualityProfileID,
+				tags:             series.Tags,
+				seasonFiles:      seasonFiles,
+			}
+		}
+
+		movies, err := c.radarrClient.GetAllMovies(ctx)
+		if err != nil {
+			c.arrTitlesErr["Movie"] = fmt.Errorf("failed to get movies from Radarr: %w", err)
+		}
+		for _, movie := range movies {
+			c.arrTitles["Movie:"+strconv.Itoa(movie.TMDBID)] = arrTitle{
+				monitored:        movie.Monitored,
+				qualityProfileID: movie.QualityProfileID,
+				tags:             movie.Tags,
 			}
 		}
 	}
 
-	return false, ""
+	if err := c.arrTitlesErr[item.Type]; err != nil {
+		return arrTitle{}, false, err
+	}
+	title, ok := c.arrTitles[mediaKey(item)]
+	return title, ok, nil
 }
 
 func formatExpirationTag(expirationDate time.Time) string {
 	return expireTagConst + expirationDate.Format("2006-01-02")
 }
 
-func processItemsDueForDeletion(cfg *config.Config, jellyfinClient *jellyfin.Client, sonarrClient *sonarr.Client, radarrClient *radarr.Client) {
+func (c *cleaner) processItemsDueForDeletion(ctx context.Context) {
 	log.Println("Processing items due for deletion...")
 
-	// Get all items in the "Headed Out" playlist
-	playlistItems, err := jellyfinClient.GetPlaylistItems(cfg.HeadedOutPlaylist.Name)
+	now := time.Now()
+	for _, record := range c.store.Records() {
+		// Records are ordered by due date, so the rest aren't due either
+		if !now.After(record.DueAt) || ctx.Err() != nil {
+			break
+		}
+
+		item := recordItem(record)
+		library := c.libraryByName(record.Library)
+		if library == nil {
+			library = c.libraryFor(ctx, item)
+		}
+
+		// A keep vote cast since the last mark phase still cancels the deletion,
+		// unless a downgrade has already started
+		var keptBy string
+		kept := false
+		if record.Downgrade == nil {
+			keptBy, kept = c.rescued(ctx, item)
+		}
+		if kept {
+			if c.plan != nil {
+				c.plan.Add(plan.Entry{
+					ItemID:  item.ID,
+					Item:    item.Name,
+					Type:    item.Type,
+					Library: record.Library,
+					Reason:  "Kept by " + keptBy,
+					Action:  plan.ActionUnmark,
+				})
+				continue
+			}
+			if err := c.store.Delete(item.ID); err != nil {
+				log.Errorf("Failed to remove %s from state store: %v", item.Name, err)
+			}
+			c.clearMirror(ctx, item)
+			continue
+		}
+
+		if item.Type == "Season" {
+			log.Infof("Cleaning up watched season: %s (Expiration: %s)", item.Name, record.DueAt.Format("2006-01-02"))
+			if err := c.cleanupSeason(ctx, record, library); err != nil {
+				log.Errorf("Failed to clean up %s: %v", item.Name, err)
+				continue
+			}
+		} else if library != nil && library.SeasonCleanup.Enabled && item.Type == "Series" {
+			// Only seasons are cleaned up in this library, the mark phase drops the record
+			log.Warnf("Skipping %s, season cleanup doesn't delete whole series", item.Name)
+			continue
+		} else if library != nil && library.Action == config.ActionDowngrade {
+			log.Infof("Downgrading content: %s (Expiration: %s)", item.Name, record.DueAt.Format("2006-01-02"))
+			done, err := c.downgradeContent(ctx, record, library)
+			if err != nil {
+				log.Errorf("Failed to downgrade %s: %v", item.Name, err)
+				continue
+			}
+			if !done {
+				continue
+			}
+		} else if library != nil && library.Action != config.ActionDelete {
+			log.Infof("Unmonitoring content: %s (Expiration: %s)", item.Name, record.DueAt.Format("2006-01-02"))
+			if err := c.unmonitorContent(ctx, record, library); err != nil {
+				log.Errorf("Failed to unmonitor %s: %v", item.Name, err)
+				continue
+			}
+		} else {
+			log.Infof("Deleting content: %s (Expiration: %s)", item.Name, record.DueAt.Format("2006-01-02"))
+			if err := c.deleteContent(ctx, record, library); err != nil {
+				log.Errorf("Failed to delete %s: %v", item.Name, err)
+				continue
+			}
+		}
+
+		if c.plan != nil {
+			continue
+		}
+
+		if err := c.store.Delete(item.ID); err != nil {
+			log.Errorf("Failed to remove %s from state store: %v", item.Name, err)
+		}
+		c.clearMirror(ctx, item)
+	}
+
+	c.saveKeptItems()
+
+	if c.cfg.Recycle.Enabled {
+		c.purgeRecycleBin()
+	}
+}
+
+// deleteContent deletes a series or movie from Sonarr/Radarr and Jellyseerr
+func (c *cleaner) deleteContent(ctx context.Context, record state.Record, library *config.Library) error {
+	item := recordItem(record)
+	if c.plan != nil {
+		c.plan.Add(plan.Entry{
+			ItemID:         item.ID,
+			Item:           item.Name,
+			Type:           item.Type,
+			Library:        libraryName(library),
+			Rule:           record.Rule,
+			Reason:         record.Reason,
+			Action:         plan.ActionDelete,
+			ExpirationDate: record.DueAt.Format("2006-01-02"),
+		})
+		return nil
+	}
+
+	entry := c.newAuditEntry(ctx, record, library, audit.ActionDelete)
+
+	// Files are kept if the library asks for it, or moved to the recycle bin in recycle mode
+	keepFiles := library != nil && !library.ShouldDeleteFiles()
+	recycleFiles := !keepFiles && c.cfg.Recycle.Enabled
+	deleteFiles := !keepFiles && !recycleFiles
+	importListExclusion := library != nil && library.AddImportListExclusion
+
+	// Delete from Sonarr or Radarr first
+	if item.Type == "Series" {
+		series, err := c.sonarrClient.GetSeriesByTVDBID(ctx, item.ExternalID)
+		if err != nil {
+			return fmt.Errorf("failed to find series in Sonarr: %w", err)
+		}
+		entry.Arr = sonarrEntity(series)
+		if err := c.sonarrClient.DeleteSeriesByID(ctx, series.ID, sonarr.DeleteOptions{
+			DeleteFiles:            deleteFiles,
+			AddImportListExclusion: importListExclusion,
+		}); err != nil {
+			return fmt.Errorf("failed to delete series from Sonarr: %w", err)
+		}
+	} else if item.Type == "Movie" {
+		movie, err := c.radarrClient.GetMovieByTMDBID(ctx, item.ExternalID)
+		if err != nil {
+			return fmt.Errorf("failed to find movie in Radarr: %w", err)
+		}
+		entry.Arr = radarrEntity(movie)
+		if err := c.radarrClient.DeleteMovieByID(ctx, movie.ID, radarr.DeleteOptions{
+			DeleteFiles:            deleteFiles,
+			AddImportListExclusion: importListExclusion,
+		}); err != nil {
+			return fmt.Errorf("failed to delete movie from Radarr: %w", err)
+		}
+	}
+	if recycleFiles && entry.Arr != nil {
+		entry.RecyclePath = c.recycle(entry.Arr.Path)
+	}
+	c.appendAudit(entry)
+
+	// Try to remove it from Jellyseerr
+	if err := c.jellyseerrClient.DeleteMediaFromJellyseerr(ctx, jellyseerrMediaType(item.Type), item.ExternalID); err != nil {
+		log.Warnf("Failed to remove content (%s) from Jellyseerr: %v", item.Name, err)
+	}
+
+	log.Infof("Successfully deleted: %s", item.Name)
+	return nil
+}
+
+// cleanupSeason deletes the episode files of a watched season and unmonitors
+// it, keeping the series and its future episodes in Sonarr
+func (c *cleaner) cleanupSeason(ctx context.Context, record state.Record, library *config.Library) error {
+	if c.plan != nil {
+		c.plan.Add(plan.Entry{
+			ItemID:         record.ItemID,
+			Item:           record.Name,
+			Type:           record.Type,
+			Library:        libraryName(library),
+			Rule:           record.Rule,
+			Reason:         record.Reason,
+			Action:         plan.ActionDeleteSeason,
+			ExpirationDate: record.DueAt.Format("2006-01-02"),
+		})
+		return nil
+	}
+
+	series, err := c.sonarrClient.GetSeriesByTVDBID(ctx, record.ExternalID)
+	if err != nil {
+		return fmt.Errorf("failed to find series in Sonarr: %w", err)
+	}
+
+	entry := c.newAuditEntry(ctx, record, library, audit.ActionDeleteSeason)
+	entry.Arr = sonarrEntity(series)
+	entry.Seasons = []int{record.Season}
+
+	entry.RecyclePath, err = c.deleteEpisodeFiles(ctx, series, record.Season)
+	if err != nil {
+		return err
+	}
+
+	if err := c.sonarrClient.SetSeasonMonitored(ctx, series.ID, record.Season, false); err != nil {
+		return fmt.Errorf("failed to unmonitor season %d: %w", record.Season, err)
+	}
+	c.appendAudit(entry)
+
+	log.Infof("Successfully cleaned up: %s", record.Name)
+	return nil
+}
+
+// recordItem returns the Jellyfin item a state record refers to
+func recordItem(record state.Record) jellyfin.Item {
+	return jellyfin.Item{
+		ID:         record.ItemID,
+		Name:       record.Name,
+		Type:       record.Type,
+		ExternalID: record.ExternalID,
+	}
+}
+
+// libraryByName returns the configured library with the given name, or nil if there is none
+func (c *cleaner) libraryByName(name string) *config.Library {
+	for i := range c.cfg.Jellyfin.Libraries {
+		if c.cfg.Jellyfin.Libraries[i].Name == name {
+			return &c.cfg.Jellyfin.Libraries[i]
+		}
+	}
+	return nil
+}
+
+// libraryFor returns the configured library containing item, or nil if it can't be determined
+func (c *cleaner) libraryFor(ctx context.Context, item jellyfin.Item) *config.Library {
+	name, err := c.jellyfinClient.GetItemLibraryName(ctx, item.ID)
 	if err != nil {
-		log.Infof("Error getting playlist items: %v", err)
+		log.Warnf("Failed to determine library of %s: %v", item.Name, err)
+		return nil
+	}
+
+	return c.libraryByName(name)
+}
+
+func libraryName(library *config.Library) string {
+	if library == nil {
+		return ""
+	}
+	return library.Name
+}
+
+// mirrorRecord makes sure a marked item is in the "Headed Out" playlist and
+// carries exactly one expiration tag matching its due date. The item's tags
+// are taken from the library snapshot.
+func (c *cleaner) mirrorRecord(ctx context.Context, record state.Record, item jellyfin.Item) {
+	if !c.inHeadedOut(ctx, record.ItemID) {
+		if err := c.jellyfinClient.AddToPlaylist(ctx, record.ItemID, c.cfg.HeadedOutPlaylist.Name); err != nil {
+			log.Infof("Failed to add %s to playlist: %v", record.Name, err)
+		} else {
+			c.setHeadedOut(record.ItemID, true)
+		}
+	}
+
+	// Replace all expiration tags in one update when the snapshot shows they differ
+	expected := formatExpirationTag(record.DueAt)
+	var current []string
+	for _, tag := range item.Tags {
+		if strings.HasPrefix(tag, expireTagConst) {
+			current = append(current, tag)
+		}
+	}
+	if len(current) == 1 && current[0] == expected {
 		return
 	}
+	if err := c.jellyfinClient.SetExpirationTag(ctx, record.ItemID, expected); err != nil {
+		log.Infof("Failed to set expiration tag of %s: %v", record.Name, err)
+	}
+}
+
+// clearMirror removes an item from the "Headed Out" playlist and drops its expiration tags
+func (c *cleaner) clearMirror(ctx context.Context, item jellyfin.Item) {
+	if item.Type == "Season" {
+		return // Seasons are only tracked in the state store, see markSeasons
+	}
+	if c.inHeadedOut(ctx, item.ID) {
+		if err := c.jellyfinClient.RemoveFromPlaylist(ctx, item.ID, c.cfg.HeadedOutPlaylist.Name); err != nil {
+			log.Errorf("Failed to remove %s from playlist: %v", item.Name, err)
+		} else {
+			c.setHeadedOut(item.ID, false)
+		}
+	}
+	if err := c.jellyfinClient.SetExpirationTag(ctx, item.ID, ""); err != nil {
+		log.Errorf("Failed to remove expiration tags from %s: %v", item.Name, err)
+	}
+}
+
+// inHeadedOut reports whether an item is in the "Headed Out" playlist. The
+// playlist is loaded once per run; a missing playlist counts as empty.
+func (c *cleaner) inHeadedOut(ctx context.Context, itemID string) bool {
+	c.headedOutMu.Lock()
+	defer c.headedOutMu.Unlock()
+
+	if c.headedOut == nil {
+		c.headedOut = make(map[string]bool)
+		items, err := c.jellyfinClient.GetPlaylistItems(ctx, c.cfg.HeadedOutPlaylist.Name)
+		if err != nil {
+			log.Debugf("Treating %s playlist as empty: %v", c.cfg.HeadedOutPlaylist.Name, err)
+		}
+		for _, item := range items {
+			c.headedOut[item.ID] = true
+		}
+	}
+	return c.headedOut[itemID]
+}
+
+// setHeadedOut keeps the cached "Headed Out" playlist in step with changes made to it
+func (c *cleaner) setHeadedOut(itemID string, in bool) {
+	c.headedOutMu.Lock()
+	defer c.headedOutMu.Unlock()
+
+	if c.headedOut != nil {
+		c.headedOut[itemID] = in
+	}
+}
+
+// importFromJellyfin seeds a new state store from the "Headed Out" playlist
+// and expiration tags left by earlier versions of jellycleaner
+func (c *cleaner) importFromJellyfin(ctx context.Context) error {
+	playlistItems, err := c.jellyfinClient.GetPlaylistItems(ctx, c.cfg.HeadedOutPlaylist.Name)
+	if err != nil {
+		// No playlist means nothing was marked yet
+		return nil
+	}
 
-	now := time.Now()
 	for _, item := range playlistItems {
-		// Get expiration tag
-		expirationTags := jellyfinClient.GetExpirationTags(item.ID)
-		for _, tag := range expirationTags {
-			// Parse expiration date from tag
-			expDate, err := parseExpirationDate(tag)
+		for _, tag := range c.jellyfinClient.GetExpirationTags(ctx, item.ID) {
+			dueAt, err := parseExpirationDate(tag)
 			if err != nil {
 				log.Infof("Error parsing expiration date for %s: %v", item.Name, err)
 				continue
 			}
 
-			// Check if it's time to delete
-			if now.After(expDate) {
-				log.Infof("Deleting content: %s (Expiration: %s)", item.Name, expDate.Format("2006-01-02"))
-				
-				// Delete from Sonarr or Radarr first
-				if item.Type == "Series" {
-					if err := sonarrClient.DeleteSeries(item.ExternalID); err != nil {
-						log.Errorf("Failed to delete series from Sonarr: %v", err)
-						continue
-					}
-				} else if item.Type == "Movie" {
-					if err := radarrClient.DeleteMovie(item.ExternalID); err != nil {
-						log.Errorf("Failed to delete movie from Radarr: %v", err)
-						continue
-					}
-				}
-
-				// Remove from Jellyfin playlist and delete tags
-				if err := jellyfinClient.RemoveFromPlaylist(item.ID, cfg.HeadedOutPlaylist.Name); err != nil {
-					log.Errorf("Failed to remove %s from playlist: %v", item.Name, err)
-				}
-				if err := jellyfinClient.RemoveTag(item.ID, tag); err != nil {
-					log.Errorf("Failed to remove expiration tag from %s: %v", item.Name, err)
-				}
-				
-				// Try to remove it from Jellyseerr
-				if err := jellyseerrClient.Remove(item); err != nil { 
-					log.Warnf("Failed to remove content (%s) from Jellyseerr: %v", item.Name, err)
-				}
-
-				log.Infof("Successfully deleted: %s", item.Name)
+			log.Infof("Importing marked item into state store: %s (Expiration: %s)", item.Name, dueAt.Format("2006-01-02"))
+			if err := c.store.Put(state.Record{
+				ItemID:     item.ID,
+				Name:       item.Name,
+				Type:       item.Type,
+				ExternalID: item.ExternalID,
+				Reason:     "Imported from Jellyfin",
+				MarkedAt:   time.Now(),
+				DueAt:      dueAt,
+			}); err != nil {
+				return err
 			}
+			break
 		}
 	}
+
+	return nil
 }
 
 func parseExpirationDate(tag string) (time.Time, error) {
-	
-	dateStr := 
+	dateStr := strings.TrimPrefix(tag, expireTagConst)
 	return time.Parse("2006-01-02", dateStr)
 }
 
+// jellyseerrMediaType maps a Jellyfin item type to the Jellyseerr media type
+func jellyseerrMediaType(itemType string) string {
+	if itemType == "Series" || itemType == "Season" {
+		return "tv"
+	}
+	return strings.ToLower(itemType)
+}
+
+// writePlan writes the dry-run plan to path, or to stdout when path is empty
+func writePlan(p *plan.Plan, path string) error {
+	if path == "" {
+		return p.Write(os.Stdout)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return p.Write(f)
+}
+
 func getConfigPath() string {
 	// Check if config path is set via environment variable
 	configPath := os.Getenv("JELLYCLEANER_CONFIG")
@@ -227,4 +1227,4 @@ func getConfigPath() string {
 	}
 	// Default to config.yaml in current directory
 	return "config.yaml"
-}
\ No newline at end of file
+}
@@ -0,0 +1,459 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alex4108/jellycleaner/config"
+	"github.com/alex4108/jellycleaner/internal/exclusions"
+	"github.com/alex4108/jellycleaner/internal/httpx"
+	"github.com/alex4108/jellycleaner/internal/jellyfin"
+	"github.com/alex4108/jellycleaner/internal/jellyseerr"
+	"github.com/alex4108/jellycleaner/internal/notify"
+	"github.com/alex4108/jellycleaner/internal/radarr"
+	"github.com/alex4108/jellycleaner/internal/rules"
+	"github.com/alex4108/jellycleaner/internal/sonarr"
+	"github.com/alex4108/jellycleaner/internal/state"
+)
+
+// newJellyfinServer serves a "Headed Out" playlist with the given items and their tags.
+// Without items there is no playlist.
+func newJellyfinServer(t *testing.T, items []map[string]interface{}, tags map[string][]string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var response interface{}
+		switch r.URL.Path {
+		case "/Playlists":
+			playlists := []map[string]string{}
+			if items != nil {
+				playlists = append(playlists, map[string]string{"Id": "p1", "Name": "Headed Out"})
+			}
+			response = map[string]interface{}{"Items": playlists}
+		case "/Playlists/p1/Items":
+			response = map[string]interface{}{"Items": items}
+		default:
+			itemID := filepath.Base(r.URL.Path)
+			response = map[string]interface{}{"Id": itemID, "Tags": tags[itemID]}
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestCleaner(t *testing.T, server *httptest.Server) *cleaner {
+	t.Helper()
+	jellyfinClient, err := jellyfin.NewClient(server.URL, "key", httpx.Options{MaxRetries: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := state.Open(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{}
+	cfg.HeadedOutPlaylist.Name = "Headed Out"
+	return &cleaner{cfg: cfg, jellyfinClient: jellyfinClient, store: store}
+}
+
+func TestImportFromJellyfin(t *testing.T) {
+	server := newJellyfinServer(t, []map[string]interface{}{
+		{"Id": "m1", "Name": "Old Movie", "Type": "Movie", "ProviderIds": map[string]string{"Tmdb": "11"}},
+		{"Id": "s1", "Name": "Old Show", "Type": "Series", "ProviderIds": map[string]string{"Tvdb": "81189"}},
+		{"Id": "m2", "Name": "Untagged", "Type": "Movie"},
+		{"Id": "m3", "Name": "Bad Tag", "Type": "Movie"},
+	}, map[string][]string{
+		"m1": {"4K", expireTagConst + "2026-11-01"},
+		"s1": {expireTagConst + "2026-11-15"},
+		"m3": {expireTagConst + "soon"},
+	})
+	c := newTestCleaner(t, server)
+
+	if err := c.importFromJellyfin(context.Background()); err != nil {
+		t.Fatalf("importFromJellyfin: %v", err)
+	}
+
+	tests := []struct {
+		itemID     string
+		imported   bool
+		externalID string
+		dueAt      time.Time
+	}{
+		{"m1", true, "11", time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)},
+		{"s1", true, "81189", time.Date(2026, 11, 15, 0, 0, 0, 0, time.UTC)},
+		{"m2", false, "", time.Time{}},
+		{"m3", false, "", time.Time{}},
+	}
+	for _, tt := range tests {
+		record, ok := c.store.Get(tt.itemID)
+		if ok != tt.imported {
+			t.Errorf("%s imported = %v, want %v", tt.itemID, ok, tt.imported)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if record.ExternalID != tt.externalID || !record.DueAt.Equal(tt.dueAt) {
+			t.Errorf("%s imported as %+v, want external ID %s due %s", tt.itemID, record, tt.externalID, tt.dueAt)
+		}
+	}
+	if c.store.Created() {
+		t.Error("imported records weren't saved")
+	}
+}
+
+func TestImportFromJellyfinWithoutPlaylist(t *testing.T) {
+	c := newTestCleaner(t, newJellyfinServer(t, nil, nil))
+
+	if err := c.importFromJellyfin(context.Background()); err != nil {
+		t.Fatalf("importFromJellyfin: %v", err)
+	}
+	if records := c.store.Records(); len(records) != 0 {
+		t.Errorf("imported %d records without a playlist", len(records))
+	}
+}
+
+func TestMarkSeasons(t *testing.T) {
+	c := newTestCleaner(t, newJellyfinServer(t, nil, nil))
+	c.cfg.HeadedOutPlaylist.DeletionDelayDays = 7
+	c.digest = &notify.Digest{}
+	c.requestIndex = jellyseerr.NewRequestIndex(nil)
+	store := c.store
+
+	series := jellyfin.Item{ID: "s1", Name: "Show", Type: "Series", ExternalID: "21"}
+	library := config.Library{Name: "TV Shows"}
+	now := time.Now()
+	for _, record := range []state.Record{
+		{ItemID: "s1", Name: "Show", Type: "Series", ExternalID: "21", DueAt: now}, // Marked by an earlier version
+		{ItemID: "sea2", Name: "Show - Season 2", Type: "Season", SeriesID: "s1", Season: 2, DueAt: now},
+		{ItemID: "other", Name: "Other - Season 1", Type: "Season", SeriesID: "s2", Season: 1, DueAt: now},
+	} {
+		if err := store.Put(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	matched := evaluation{
+		result:  rules.Result{Matched: true, Rule: "max_age_days(30)", Reason: "Exceeds maximum age"},
+		seasons: []jellyfin.Season{{ID: "sea1", Name: "Season 1", Number: 1}},
+	}
+	c.markSeasons(context.Background(), series, library, matched)
+	c.markSeasons(context.Background(), series, library, matched)
+
+	record, ok := store.Get("sea1")
+	if !ok {
+		t.Fatal("watched season wasn't marked")
+	}
+	if record.Type != "Season" || record.SeriesID != "s1" || record.Season != 1 || record.ExternalID != "21" || record.Name != "Show - Season 1" {
+		t.Errorf("season marked as %+v", record)
+	}
+	if len(c.digest.Marked) != 1 {
+		t.Errorf("digest has %d marked items, want the season once", len(c.digest.Marked))
+	}
+	if _, ok := store.Get("s1"); ok {
+		t.Error("series record wasn't dropped")
+	}
+	if _, ok := store.Get("sea2"); ok {
+		t.Error("season that isn't selected anymore is still marked")
+	}
+	if _, ok := store.Get("other"); !ok {
+		t.Error("season of another series was unmarked")
+	}
+
+	c.markSeasons(context.Background(), series, library, evaluation{})
+	if _, ok := store.Get("sea1"); ok {
+		t.Error("season is still marked after its series stopped matching")
+	}
+}
+
+// failingRule stands in for a rule whose metadata can't be fetched
+type failingRule struct{}
+
+func (failingRule) Name() string { return "failing" }
+
+func (failingRule) Evaluate(ctx context.Context, item jellyfin.Item, md rules.Metadata) (rules.Result, error) {
+	return rules.Result{}, errors.New("jellyseerr is down")
+}
+
+func TestEvaluateSkipsOnRuleError(t *testing.T) {
+	c := newTestCleaner(t, newJellyfinServer(t, nil, nil))
+	item := jellyfin.Item{ID: "m1", Name: "Old Movie", Type: "Movie", ExternalID: "11"}
+	if err := c.store.Put(state.Record{ItemID: "m1", Name: "Old Movie", Type: "Movie", DueAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	excluder, err := exclusions.Compile(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ev := c.evaluate(context.Background(), item, config.Library{Action: config.ActionDelete}, failingRule{}, nil, excluder, c.sources(), nil)
+	if !ev.skip {
+		t.Errorf("evaluation = %+v, want the item skipped so it stays marked", ev)
+	}
+}
+
+func TestRequestsCachesFailure(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+	jellyseerrClient, err := jellyseerr.NewClient(server.URL, "key", httpx.Options{MaxRetries: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &cleaner{jellyseerrClient: jellyseerrClient}
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.requests(context.Background()); err == nil {
+			t.Fatal("requests succeeded although Jellyseerr is down")
+		}
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("Jellyseerr was asked %d times, want once per run", got)
+	}
+}
+
+// newArrCleaner returns a test cleaner whose Sonarr fails and whose Radarr has no movies
+func newArrCleaner(t *testing.T) *cleaner {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/sonarr/api/v3/series" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("[]"))
+	}))
+	t.Cleanup(server.Close)
+
+	c := newTestCleaner(t, newJellyfinServer(t, nil, nil))
+	var err error
+	if c.sonarrClient, err = sonarr.NewClient(server.URL+"/sonarr", "key", httpx.Options{MaxRetries: -1}); err != nil {
+		t.Fatal(err)
+	}
+	if c.radarrClient, err = radarr.NewClient(server.URL+"/radarr", "key", httpx.Options{MaxRetries: -1}); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestArrTitleFailure(t *testing.T) {
+	c := newArrCleaner(t)
+	ctx := context.Background()
+	series := jellyfin.Item{ID: "s1", Name: "Show", Type: "Series", ExternalID: "21"}
+
+	if _, _, err := c.arrTitle(ctx, series); err == nil {
+		t.Error("arrTitle hid the Sonarr failure")
+	}
+	if _, ok, err := c.arrTitle(ctx, jellyfin.Item{ID: "m1", Type: "Movie", ExternalID: "11"}); ok || err != nil {
+		t.Errorf("arrTitle for a movie missing from Radarr = %v, %v, want not found", ok, err)
+	}
+
+	// An item that may be protected by a Sonarr tag is left alone
+	excluder, err := exclusions.Compile([]string{"arr_tag:keep"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := c.evaluate(ctx, series, config.Library{Action: config.ActionDelete}, rules.MaxAge{Days: 1}, nil, excluder, c.sources(), nil)
+	if !ev.skip {
+		t.Errorf("evaluation = %+v, want the item skipped", ev)
+	}
+}
+
+func TestArrTagsCachesFailure(t *testing.T) {
+	var tagRequests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sonarr/api/v3/series":
+			json.NewEncoder(w).Encode([]map[string]interface{}{
+				{"id": 1, "tvdbId": 21, "tags": []int{1}},
+				{"id": 2, "tvdbId": 22, "tags": []int{1}},
+			})
+		case "/sonarr/api/v3/tag":
+			atomic.AddInt32(&tagRequests, 1)
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.Write([]byte("[]"))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	c := newTestCleaner(t, newJellyfinServer(t, nil, nil))
+	var err error
+	if c.sonarrClient, err = sonarr.NewClient(server.URL+"/sonarr", "key", httpx.Options{MaxRetries: -1}); err != nil {
+		t.Fatal(err)
+	}
+	if c.radarrClient, err = radarr.NewClient(server.URL+"/radarr", "key", httpx.Options{MaxRetries: -1}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"21", "22", "21"} {
+		if _, err := c.arrTags(context.Background(), jellyfin.Item{ID: "s" + id, Type: "Series", ExternalID: id}); err == nil {
+			t.Errorf("arrTags of series %s hid the Sonarr failure", id)
+		}
+	}
+	if n := atomic.LoadInt32(&tagRequests); n != 1 {
+		t.Errorf("Sonarr tags were requested %d times, want once per run", n)
+	}
+}
+
+func TestEvaluateSeasonsSkipsOnSonarrFailure(t *testing.T) {
+	c := newArrCleaner(t)
+	series := jellyfin.Item{ID: "s1", Name: "Show", Type: "Series", ExternalID: "21"}
+	excluder, err := exclusions.Compile(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seasonRule := &rules.WatchedBy{}
+
+	// The series matches through disk pressure; its seasons can't be checked without Sonarr
+	pressure := map[string]rules.Result{"s1": {Matched: true, Rule: "disk_pressure(oldest)"}}
+	ev := c.evaluate(context.Background(), series, config.Library{Action: config.ActionDelete}, nil, seasonRule, excluder, c.sources(), pressure)
+	if !ev.skip {
+		t.Errorf("evaluation = %+v, want the series skipped so its season marks are kept", ev)
+	}
+}
+
+// newTagServer serves items whose tags can be read and replaced through
+// /Items/{id}. Jellyfin replaces the whole item on update, so a POST that
+// drops any of the item's other fields fails the test.
+func newTagServer(t *testing.T, tags map[string][]string) *httptest.Server {
+	t.Helper()
+	var mu sync.Mutex
+	fullItem := func(itemID string) map[string]interface{} {
+		return map[string]interface{}{
+			"Id":           itemID,
+			"Name":         "Item " + itemID,
+			"Overview":     "An overview",
+			"Genres":       []interface{}{"Drama"},
+			"People":       []interface{}{map[string]interface{}{"Name": "Someone", "Type": "Actor"}},
+			"ProviderIds":  map[string]interface{}{"Tmdb": "11"},
+			"LockedFields": []interface{}{"Name"},
+			"Tags":         tags[itemID],
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		itemID := filepath.Base(r.URL.Path)
+		if r.Method == "POST" {
+			var body map[string]json.RawMessage
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			for field, value := range fullItem(itemID) {
+				if field == "Tags" {
+					continue
+				}
+				if want := mustMarshal(t, value); !bytes.Equal(body[field], want) {
+					t.Errorf("update of %s sent %s = %s, want %s", itemID, field, body[field], want)
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+			}
+			var update struct {
+				Tags []string `json:"Tags"`
+			}
+			if err := json.Unmarshal(body["Tags"], &update.Tags); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			tags[itemID] = update.Tags
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		json.NewEncoder(w).Encode(fullItem(itemID))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestMirrorRecordReplacesStaleTag(t *testing.T) {
+	due := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+	stale := expireTagConst + "2026-10-01"
+	tags := map[string][]string{"m1": {"4K", stale}}
+	c := newTestCleaner(t, newTagServer(t, tags))
+	c.headedOut = map[string]bool{"m1": true}
+
+	item := jellyfin.Item{ID: "m1", Name: "Old Movie", Type: "Movie", ExternalID: "11", Tags: []string{"4K", stale}}
+	c.mirrorRecord(context.Background(), state.Record{ItemID: "m1", Name: "Old Movie", DueAt: due}, item)
+
+	want := []string{"4K", formatExpirationTag(due)}
+	if got := tags["m1"]; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("tags = %v, want %v", got, want)
+	}
+}
+
+func TestClearMirrorOnlyWritesTaggedItems(t *testing.T) {
+	tags := map[string][]string{"m1": {"4K", expireTagConst + "2026-10-01"}}
+	c := newTestCleaner(t, newTagServer(t, tags))
+	c.headedOut = map[string]bool{}
+
+	c.clearMirror(context.Background(), jellyfin.Item{ID: "m1", Name: "Old Movie", Type: "Movie"})
+	c.clearMirror(context.Background(), jellyfin.Item{ID: "m2", Name: "New Movie", Type: "Movie"})
+
+	if got := tags["m1"]; strings.Join(got, ",") != "4K" {
+		t.Errorf("m1 tags = %v, want [4K]", got)
+	}
+	// An update would have stored m2's tags, even though there are none
+	if _, written := tags["m2"]; written {
+		t.Error("m2 was updated although it has no expiration tags")
+	}
+}
+
+func TestKeepVotesSavedOnce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	store, err := state.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{Workers: 4}
+	cfg.KeepVote = config.KeepVoteConfig{Enabled: true, Favorites: true, ProtectionDays: 30}
+	c := &cleaner{cfg: cfg, store: store, playStates: make(map[string][]jellyfin.UserPlayState)}
+
+	items := make([]jellyfin.Item, 20)
+	for i := range items {
+		items[i] = jellyfin.Item{ID: "m" + strconv.Itoa(i), Name: "Movie", Type: "Movie"}
+		c.playStates[items[i].ID] = []jellyfin.UserPlayState{{User: jellyfin.User{ID: "u1", Name: "alice"}, IsFavorite: true}}
+	}
+
+	c.forEach(context.Background(), len(items), func(i int) {
+		if _, ok := c.rescued(context.Background(), items[i]); !ok {
+			t.Errorf("%s wasn't rescued by a favorite", items[i].ID)
+		}
+	})
+	if _, err := os.Stat(path); err == nil {
+		t.Fatal("state file was written while items were evaluated")
+	}
+
+	c.saveKeptItems()
+	for _, item := range items {
+		if !c.protected(item) {
+			t.Errorf("%s isn't protected after the keep votes were saved", item.ID)
+		}
+	}
+}
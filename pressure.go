@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/alex4108/jellycleaner/config"
+	"github.com/alex4108/jellycleaner/internal/diskspace"
+	"github.com/alex4108/jellycleaner/internal/exclusions"
+	"github.com/alex4108/jellycleaner/internal/jellyfin"
+	"github.com/alex4108/jellycleaner/internal/rules"
+	"github.com/alex4108/jellycleaner/internal/state"
+)
+
+const bytesPerGB = 1 << 30
+
+// pressureCandidate is an item that could be marked to free disk space
+type pressureCandidate struct {
+	item  jellyfin.Item
+	size  int64
+	score float64 // Lower scores are marked first
+	added time.Time
+}
+
+// pressureRule names the rule of items marked because of disk pressure
+const pressureRule = "disk_pressure"
+
+// diskPressureCandidates selects items to mark so that free space reaches
+// disk_pressure.target_free_gb. Only titles on the watched disk whose library
+// action frees space are candidates. Items that are already marked count
+// towards the target, since they are deleted anyway, and items marked because
+// of disk pressure stay marked until the target is reached. The returned map
+// is keyed by Jellyfin item ID.
+func (c *cleaner) diskPressureCandidates(ctx context.Context, libraryItems map[string][]jellyfin.Item) map[string]rules.Result {
+	dp := c.cfg.DiskPressure
+	if !dp.Enabled {
+		return nil
+	}
+
+	disk, err := c.watchedDisk(ctx)
+	if err != nil {
+		// Without a measurement, keep what disk pressure marked earlier
+		log.Errorf("Failed to read free disk space: %v", err)
+		return c.pressureRecords()
+	}
+
+	free := disk.free
+	target := int64(dp.TargetFreeGB * bytesPerGB)
+	if free >= target {
+		log.Infof("Free space %.1f GB meets the %.1f GB target", gigabytes(free), dp.TargetFreeGB)
+		return nil
+	}
+	log.Infof("Free space %.1f GB is below the %.1f GB target, selecting candidates", gigabytes(free), dp.TargetFreeGB)
+
+	selected := c.pressureRecords()
+	usages, err := c.mediaUsages(ctx)
+	if err != nil {
+		log.Errorf("Failed to get media sizes: %v", err)
+		return selected
+	}
+
+	marked := make(map[string]bool)
+	for _, record := range c.store.Records() {
+		marked[record.ItemID] = true
+		if usage := usages[recordKey(record)]; c.recordFreesSpace(record) && disk.holds(usage.path) {
+			free += usage.size
+		}
+	}
+
+	sources := c.sources()
+	var candidates []pressureCandidate
+	for _, library := range c.cfg.Jellyfin.Libraries {
+		// Season cleanup only marks seasons, not whole titles
+		if library.SeasonCleanup.Enabled || !c.freesSpace(library) {
+			continue
+		}
+		excluder, err := exclusions.Compile(library.Exclusions)
+		if err != nil {
+			log.Errorf("Invalid exclusions for library %s: %v", library.Name, err)
+			continue
+		}
+
+		// Score the items concurrently, keeping them in library order
+		items := libraryItems[library.Name]
+		scored := make([]*pressureCandidate, len(items))
+		c.forEach(ctx, len(items), func(i int) {
+			item := items[i]
+			if marked[item.ID] {
+				return
+			}
+			if exclusion, err := excluder.Match(ctx, item, c.exclusionSources()); exclusion != "" || err != nil {
+				return
+			}
+			if c.protected(item) {
+				return
+			}
+
+			usage := usages[mediaKey(item)]
+			if usage.size == 0 || !disk.holds(usage.path) {
+				return
+			}
+
+			candidate, err := scoreCandidate(ctx, item, usage.size, dp.Score, rules.NewMetadata(sources, item))
+			if err != nil {
+				log.Infof("Error scoring %s for disk pressure: %v", item.Name, err)
+				return
+			}
+			scored[i] = &candidate
+		})
+		for _, candidate := range scored {
+			if candidate != nil {
+				candidates = append(candidates, *candidate)
+			}
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].score != candidates[j].score {
+			return candidates[i].score < candidates[j].score
+		}
+		if !candidates[i].added.Equal(candidates[j].added) {
+			return candidates[i].added.Before(candidates[j].added)
+		}
+		return candidates[i].item.Name < candidates[j].item.Name
+	})
+
+	ruleName := fmt.Sprintf("%s(%s)", pressureRule, dp.Score)
+	for _, candidate := range candidates {
+		if free >= target {
+			break
+		}
+		selected[candidate.item.ID] = rules.Result{
+			Matched: true,
+			Rule:    ruleName,
+			Reason:  fmt.Sprintf("Disk space pressure, frees %.1f GB", gigabytes(candidate.size)),
+		}
+		free += candidate.size
+	}
+
+	if free < target {
+		log.Warnf("Not enough candidates to reach the free space target, %.1f GB short", gigabytes(target-free))
+	}
+
+	return selected
+}
+
+// pressureRecords returns the items marked because of disk pressure, so that
+// they stay marked while the disk is below the target
+func (c *cleaner) pressureRecords() map[string]rules.Result {
+	selected := make(map[string]rules.Result)
+	for _, record := range c.store.Records() {
+		if strings.HasPrefix(record.Rule, pressureRule+"(") {
+			selected[record.ItemID] = rules.Result{Matched: true, Rule: record.Rule, Reason: record.Reason}
+		}
+	}
+	return selected
+}
+
+// freesSpace reports whether a library's action frees disk space when an item expires
+func (c *cleaner) freesSpace(library config.Library) bool {
+	switch library.Action {
+	case config.ActionDelete:
+		// Recycled files are moved within the same filesystem
+		return library.ShouldDeleteFiles() && !c.cfg.Recycle.Enabled
+	case config.ActionUnmonitorAndDeleteFiles:
+		return true
+	}
+	// Unmonitoring keeps the files, downgrading replaces them
+	return false
+}
+
+// recordFreesSpace reports whether carrying out a marked record frees disk space
+func (c *cleaner) recordFreesSpace(record state.Record) bool {
+	if record.Type == "Season" {
+		return true // Season cleanup always deletes the files
+	}
+	library := c.libraryByName(record.Library)
+	return library != nil && c.freesSpace(*library)
+}
+
+// scoreCandidate computes the order in which candidates are marked
+func scoreCandidate(ctx context.Context, item jellyfin.Item, size int64, score string, md rules.Metadata) (pressureCandidate, error) {
+	added, err := md.AddedDate()
+	if err != nil {
+		return pressureCandidate{}, err
+	}
+
+	candidate := pressureCandidate{item: item, size: size, added: added}
+	switch score {
+	case config.ScoreLeastWatched:
+		playStates, err := md.PlayStates(ctx)
+		if err != nil {
+			return pressureCandidate{}, err
+		}
+		for _, state := range playStates {
+			candidate.score += float64(state.PlayCount)
+		}
+	case config.ScoreLastPlayed:
+		playStates, err := md.PlayStates(ctx)
+		if err != nil {
+			return pressureCandidate{}, err
+		}
+		lastPlayed := added
+		for _, state := range playStates {
+			if state.LastPlayedDate.After(lastPlayed) {
+				lastPlayed = state.LastPlayedDate
+			}
+		}
+		candidate.score = float64(lastPlayed.Unix())
+	default:
+		candidate.score = float64(added.Unix())
+	}
+
+	return candidate, nil
+}
+
+// pressureDisk is the disk watched for disk pressure
+type pressureDisk struct {
+	free  int64
+	holds func(arrPath string) bool // Reports whether a Sonarr/Radarr path is on the disk
+}
+
+// watchedDisk returns the free space of the disk configured for disk pressure
+func (c *cleaner) watchedDisk(ctx context.Context) (pressureDisk, error) {
+	dp := c.cfg.DiskPressure
+
+	var disks []diskUsage
+	switch dp.Source {
+	case config.DiskSourcePath:
+		usage, err := diskspace.Get(dp.Path)
+		if err != nil {
+			return pressureDisk{}, err
+		}
+		return pressureDisk{
+			free: int64(usage.Free),
+			holds: func(arrPath string) bool {
+				return arrPath != "" && underPath(c.localPath(arrPath), dp.Path)
+			},
+		}, nil
+	case config.DiskSourceSonarr:
+		sonarrDisks, err := c.sonarrClient.GetDiskSpace(ctx)
+		if err != nil {
+			return pressureDisk{}, err
+		}
+		for _, disk := range sonarrDisks {
+			disks = append(disks, diskUsage{path: disk.Path, free: disk.FreeSpace})
+		}
+	case config.DiskSourceRadarr:
+		radarrDisks, err := c.radarrClient.GetDiskSpace(ctx)
+		if err != nil {
+			return pressureDisk{}, err
+		}
+		for _, disk := range radarrDisks {
+			disks = append(disks, diskUsage{path: disk.Path, free: disk.FreeSpace})
+		}
+	}
+
+	disk, err := selectDisk(disks, dp.Path)
+	if err != nil {
+		return pressureDisk{}, err
+	}
+	return pressureDisk{
+		free: disk.free,
+		holds: func(arrPath string) bool {
+			if arrPath == "" {
+				return false
+			}
+			titleDisk, err := selectDisk(disks, arrPath)
+			return err == nil && titleDisk.path == disk.path
+		},
+	}, nil
+}
+
+type diskUsage struct {
+	path string
+	free int64
+}
+
+// selectDisk picks the disk whose path is the longest prefix of path, or
+// the disk with the least free space if no path is configured
+func selectDisk(disks []diskUsage, path string) (diskUsage, error) {
+	var selected *diskUsage
+	for i := range disks {
+		disk := &disks[i]
+		if path == "" {
+			if selected == nil || disk.free < selected.free {
+				selected = disk
+			}
+			continue
+		}
+		if underPath(path, disk.path) && (selected == nil || len(disk.path) > len(selected.path)) {
+			selected = disk
+		}
+	}
+
+	if selected == nil {
+		return diskUsage{}, fmt.Errorf("no disk found for path %q", path)
+	}
+	return *selected, nil
+}
+
+// underPath reports whether path is dir or inside it
+func underPath(path, dir string) bool {
+	dir = strings.TrimSuffix(dir, "/")
+	return path == dir || strings.HasPrefix(path, dir+"/")
+}
+
+// mediaUsage is the space a series, season or movie takes up on disk
+type mediaUsage struct {
+	size int64
+	path string // Sonarr/Radarr path of the title
+}
+
+// mediaUsages returns the disk usage of every series, season and movie, keyed
+// by mediaKey, or seasonKey for seasons
+func (c *cleaner) mediaUsages(ctx context.Context) (map[string]mediaUsage, error) {
+	usages := make(map[string]mediaUsage)
+
+	allSeries, err := c.sonarrClient.GetAllSeries(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get series from Sonarr: %w", err)
+	}
+	for _, series := range allSeries {
+		tvdbID := strconv.Itoa(series.TVDBID)
+		usages["Series:"+tvdbID] = mediaUsage{size: series.Statistics.SizeOnDisk, path: series.Path}
+		for _, season := range series.Seasons {
+			usages[seasonKey(tvdbID, season.SeasonNumber)] = mediaUsage{size: season.Statistics.SizeOnDisk, path: series.Path}
+		}
+	}
+
+	movies, err := c.radarrClient.GetAllMovies(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get movies from Radarr: %w", err)
+	}
+	for _, movie := range movies {
+		usages["Movie:"+strconv.Itoa(movie.TMDBID)] = mediaUsage{size: movie.SizeOnDisk, path: movie.FilePath}
+	}
+
+	return usages, nil
+}
+
+// seasonKey identifies a season by its series' TVDB ID and its number
+func seasonKey(tvdbID string, season int) string {
+	return "Season:" + tvdbID + ":" + strconv.Itoa(season)
+}
+
+// recordKey identifies the title or season of a marked record in mediaUsages
+func recordKey(record state.Record) string {
+	if record.Type == "Season" {
+		return seasonKey(record.ExternalID, record.Season)
+	}
+	return mediaKey(recordItem(record))
+}
+
+// mediaKey identifies an item by its type and TVDB/TMDB ID
+func mediaKey(item jellyfin.Item) string {
+	return item.Type + ":" + item.ExternalID
+}
+
+func gigabytes(bytes int64) float64 {
+	return float64(bytes) / bytesPerGB
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alex4108/jellycleaner/config"
+	"github.com/alex4108/jellycleaner/internal/state"
+)
+
+func TestSelectDisk(t *testing.T) {
+	disks := []diskUsage{
+		{path: "/", free: 50},
+		{path: "/data", free: 10},
+		{path: "/data2", free: 30},
+		{path: "/data/tv/", free: 20},
+	}
+
+	tests := []struct {
+		path     string
+		wantPath string
+		wantErr  bool
+	}{
+		{"", "/data", false}, // Least free space
+		{"/data/movies/Old Movie (2001)", "/data", false},
+		{"/data/tv/Show", "/data/tv/", false},
+		{"/data2/Show", "/data2", false},
+		{"/database", "/", false},
+		{"/", "/", false},
+	}
+
+	for _, tt := range tests {
+		disk, err := selectDisk(disks, tt.path)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("selectDisk(%q) error = %v, want error %v", tt.path, err, tt.wantErr)
+		}
+		if disk.path != tt.wantPath {
+			t.Errorf("selectDisk(%q) = %s, want %s", tt.path, disk.path, tt.wantPath)
+		}
+	}
+
+	if _, err := selectDisk([]diskUsage{{path: "/data"}}, "/media/tv"); err == nil {
+		t.Error("selectDisk found a disk for a path on none of them")
+	}
+}
+
+func TestFreesSpace(t *testing.T) {
+	keepFiles := false
+
+	tests := []struct {
+		name    string
+		library config.Library
+		recycle bool
+		want    bool
+	}{
+		{"delete", config.Library{Action: config.ActionDelete}, false, true},
+		{"delete keeping files", config.Library{Action: config.ActionDelete, DeleteFiles: &keepFiles}, false, false},
+		{"delete to recycle bin", config.Library{Action: config.ActionDelete}, true, false},
+		{"unmonitor", config.Library{Action: config.ActionUnmonitor}, false, false},
+		{"unmonitor and delete files", config.Library{Action: config.ActionUnmonitorAndDeleteFiles}, true, true},
+		{"downgrade", config.Library{Action: config.ActionDowngrade}, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &cleaner{cfg: &config.Config{}}
+			c.cfg.Recycle.Enabled = tt.recycle
+			if got := c.freesSpace(tt.library); got != tt.want {
+				t.Errorf("freesSpace = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPressureRecords(t *testing.T) {
+	store, err := state.Open(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	due := time.Now().AddDate(0, 0, 7)
+	for _, record := range []state.Record{
+		{ItemID: "m1", Rule: "disk_pressure(oldest)", Reason: "Disk space pressure, frees 5.0 GB", DueAt: due},
+		{ItemID: "m2", Rule: "max_age_days(30)", DueAt: due},
+	} {
+		if err := store.Put(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := &cleaner{store: store}
+
+	kept := c.pressureRecords()
+	if len(kept) != 1 {
+		t.Fatalf("kept %d records, want only the disk pressure record", len(kept))
+	}
+	if result := kept["m1"]; !result.Matched || result.Rule != "disk_pressure(oldest)" || result.Reason != "Disk space pressure, frees 5.0 GB" {
+		t.Errorf("kept m1 as %+v", result)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/alex4108/jellycleaner/internal/plan"
+	"github.com/alex4108/jellycleaner/internal/recycle"
+)
+
+func (c *cleaner) recycleBin() *recycle.Bin {
+	return recycle.New(c.cfg.Recycle.Path, c.cfg.Recycle.RetentionDays)
+}
+
+// recycle moves the media folder of a deleted title into the recycle bin and
+// returns its new location. Failures are logged rather than returned: the
+// title is already gone from Sonarr/Radarr and its files are left in place.
+func (c *cleaner) recycle(arrPath string) string {
+	if arrPath == "" {
+		return ""
+	}
+
+	path := c.localPath(arrPath)
+	recycled, err := c.recycleBin().Move(path, time.Now())
+	if err != nil {
+		log.Errorf("Failed to recycle %s, its files were left in place: %v", path, err)
+		return ""
+	}
+
+	log.Infof("Moved %s to %s", path, recycled)
+	return recycled
+}
+
+// recycleFiles moves files that Sonarr/Radarr are about to delete from a
+// title's folder into the recycle bin, and returns where they went. It does
+// nothing unless recycle mode is enabled. Unlike recycle, failures are
+// returned, since the files would otherwise be deleted for good.
+func (c *cleaner) recycleFiles(arrRoot string, arrPaths []string) (string, error) {
+	if !c.cfg.Recycle.Enabled || len(arrPaths) == 0 {
+		return "", nil
+	}
+
+	root := c.localPath(arrRoot)
+	paths := make([]string, len(arrPaths))
+	for i, arrPath := range arrPaths {
+		paths[i] = c.localPath(arrPath)
+	}
+	recycled, err := c.recycleBin().MoveFiles(root, paths, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	log.Infof("Moved %d files of %s to %s", len(paths), root, recycled)
+	return recycled, nil
+}
+
+// purgeRecycleBin permanently deletes media that has been in the recycle bin
+// for longer than recycle.retention_days
+func (c *cleaner) purgeRecycleBin() {
+	bin := c.recycleBin()
+	expired, err := bin.Expired(time.Now())
+	if err != nil {
+		log.Errorf("Failed to read recycle bin: %v", err)
+		return
+	}
+
+	for _, item := range expired {
+		if c.plan != nil {
+			c.plan.Add(plan.Entry{
+				ItemID: item.Path,
+				Item:   item.Name,
+				Action: plan.ActionPurge,
+			})
+			continue
+		}
+
+		if err := bin.Purge(item); err != nil {
+			log.Errorf("Failed to purge %s from recycle bin: %v", item.Name, err)
+			continue
+		}
+		log.Infof("Purged from recycle bin: %s (recycled %s)", item.Name, item.RecycledAt.Format("2006-01-02"))
+	}
+}
+
+// localPath translates a Sonarr/Radarr path using recycle.path_map, for when
+// jellycleaner sees the media under a different mount point
+func (c *cleaner) localPath(arrPath string) string {
+	prefixes := make([]string, 0, len(c.cfg.Recycle.PathMap))
+	for prefix := range c.cfg.Recycle.PathMap {
+		prefixes = append(prefixes, prefix)
+	}
+	// Longest prefix wins
+	sort.Slice(prefixes, func(i, j int) bool { return len(prefixes[i]) > len(prefixes[j]) })
+
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(arrPath, prefix) {
+			return c.cfg.Recycle.PathMap[prefix] + strings.TrimPrefix(arrPath, prefix)
+		}
+	}
+	return arrPath
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/alex4108/jellycleaner/config"
+	"github.com/alex4108/jellycleaner/internal/audit"
+	"github.com/alex4108/jellycleaner/internal/jellyfin"
+	"github.com/alex4108/jellycleaner/internal/radarr"
+	"github.com/alex4108/jellycleaner/internal/recycle"
+	"github.com/alex4108/jellycleaner/internal/sonarr"
+	"github.com/alex4108/jellycleaner/internal/state"
+)
+
+// runRestore implements the restore subcommand, which re-adds a deleted
+// title to Sonarr or Radarr from its audit log entry
+func runRestore(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	noSearch := flags.Bool("no-search", false, "Don't search for the restored title")
+	protectDays := flags.Int("protect-days", 30, "Days the restored title can't be marked again, 0 to allow it right away")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s restore [-no-search] [-protect-days n] <title|tvdb id|tmdb id>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("expected exactly one title or ID")
+	}
+
+	cfg, err := config.LoadConfig(getConfigPath())
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	c, err := newCleaner(cfg)
+	if err != nil {
+		return err
+	}
+	c.auditLog = audit.Open(cfg.Audit.Path)
+	c.store, err = state.Open(cfg.State.Path)
+	if err != nil {
+		return fmt.Errorf("failed to open state store: %w", err)
+	}
+
+	entries, err := c.auditLog.Entries()
+	if err != nil {
+		return err
+	}
+
+	restorable, err := findRestorable(entries, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range restorable {
+		log.Infof("Restoring %s (%s on %s, reason: %s)", entry.Name, entry.Action, entry.Time.Format("2006-01-02"), entry.Reason)
+		if err := c.restore(ctx, entry, !*noSearch); err != nil {
+			return err
+		}
+
+		restored := entry
+		restored.Time = time.Time{}
+		restored.Action = audit.ActionRestore
+		c.appendAudit(restored)
+
+		// Watched state survives a re-add, so without this the next run marks the title again
+		if *protectDays > 0 {
+			c.protectRestored(entry, time.Now().AddDate(0, 0, *protectDays))
+		}
+
+		log.Infof("Successfully restored: %s", entry.Name)
+	}
+	return nil
+}
+
+// findRestorable returns the most recent deletion matching query, which may be
+// a title or a TVDB/TMDB ID. It fails if that deletion was already restored.
+// Season cleanups are collected until the title's last other entry, so that
+// restoring a series brings back every season removed since, not only the last one.
+func findRestorable(entries []audit.Entry, query string) ([]audit.Entry, error) {
+	var found []audit.Entry
+	var lastRestore *audit.Entry
+	seasonsSeen := make(map[int]bool) // Seasons already collected or restored
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if !matchesAuditEntry(entry, query) {
+			continue
+		}
+
+		if isSeasonEntry(entry) {
+			var seasons []int
+			for _, season := range entry.Seasons {
+				if !seasonsSeen[season] {
+					seasons = append(seasons, season)
+				}
+				seasonsSeen[season] = true
+			}
+			switch {
+			case entry.Action == audit.ActionRestore && lastRestore == nil:
+				lastRestore = &entries[i]
+			case entry.Action == audit.ActionDeleteSeason && len(seasons) > 0:
+				if entry.Arr == nil {
+					return nil, fmt.Errorf("audit entry for %s has no Sonarr/Radarr snapshot", entry.Name)
+				}
+				entry.Seasons = seasons
+				found = append(found, entry)
+			}
+			continue
+		}
+		if len(seasonsSeen) > 0 {
+			// Anything older belongs to an earlier life of the series
+			break
+		}
+
+		switch entry.Action {
+		case audit.ActionRestore:
+			return nil, fmt.Errorf("%s was already restored on %s", entry.Name, entry.Time.Format("2006-01-02"))
+		case audit.ActionDelete, audit.ActionUnmonitor, audit.ActionUnmonitorAndDeleteFiles, audit.ActionDowngrade:
+			if entry.Arr == nil {
+				return nil, fmt.Errorf("audit entry for %s has no Sonarr/Radarr snapshot", entry.Name)
+			}
+			return []audit.Entry{entry}, nil
+		}
+	}
+
+	if len(found) > 0 {
+		return found, nil
+	}
+	if lastRestore != nil {
+		return nil, fmt.Errorf("%s was already restored on %s", lastRestore.Name, lastRestore.Time.Format("2006-01-02"))
+	}
+	return nil, fmt.Errorf("no deletion of %q found in the audit log", query)
+}
+
+// isSeasonEntry reports whether an audit entry is about single seasons rather than a whole title
+func isSeasonEntry(entry audit.Entry) bool {
+	return len(entry.Seasons) > 0 && (entry.Action == audit.ActionDeleteSeason || entry.Action == audit.ActionRestore)
+}
+
+// protectRestored keeps a restored title or its seasons from being marked
+// again until the given time. The protection is keyed by TVDB/TMDB ID, since a
+// deleted title comes back as a new Jellyfin item.
+func (c *cleaner) protectRestored(entry audit.Entry, until time.Time) {
+	var keys []string
+	switch {
+	case entry.Action == audit.ActionDeleteSeason:
+		for _, season := range entry.Seasons {
+			keys = append(keys, seasonKey(entry.TVDBID, season))
+		}
+	case entry.Type == "Series":
+		keys = append(keys, mediaKey(jellyfin.Item{Type: entry.Type, ExternalID: entry.TVDBID}))
+	case entry.Type == "Movie":
+		keys = append(keys, mediaKey(jellyfin.Item{Type: entry.Type, ExternalID: entry.TMDBID}))
+	}
+
+	for _, key := range keys {
+		protection := state.Protection{
+			ItemID: key,
+			Name:   entry.Name,
+			KeptBy: "restore",
+			Until:  until,
+		}
+		if err := c.store.Protect(protection); err != nil {
+			log.Errorf("Failed to record protection of %s in state store: %v", entry.Name, err)
+		}
+	}
+}
+
+func matchesAuditEntry(entry audit.Entry, query string) bool {
+	if query == entry.TVDBID || query == entry.TMDBID {
+		return true
+	}
+	if strings.EqualFold(query, entry.Name) {
+		return true
+	}
+	return entry.Arr != nil && strings.EqualFold(query, entry.Arr.Title)
+}
+
+// restore re-creates the title in Sonarr or Radarr with its original settings
+func (c *cleaner) restore(ctx context.Context, entry audit.Entry, search bool) error {
+	arr := entry.Arr
+
+	// Put recycled files back first, so Sonarr/Radarr find them when the title is added
+	filesRestored := false
+	if entry.RecyclePath != "" {
+		path := c.localPath(arr.Path)
+		restoreFiles := recycle.Restore
+		if entry.Action != audit.ActionDelete {
+			// Only some files were recycled, from a folder that is still there
+			restoreFiles = recycle.RestoreFiles
+		}
+		if err := restoreFiles(entry.RecyclePath, path); err != nil {
+			log.Warnf("Failed to restore files from recycle bin, they will be downloaded again: %v", err)
+		} else {
+			log.Infof("Moved %s back to %s", entry.RecyclePath, path)
+			filesRestored = true
+		}
+	}
+
+	switch {
+	case entry.Action == audit.ActionDeleteSeason:
+		series, err := c.sonarrClient.GetSeriesByTVDBID(ctx, entry.TVDBID)
+		if err != nil {
+			return fmt.Errorf("failed to find series in Sonarr: %w", err)
+		}
+		for _, season := range entry.Seasons {
+			if err := c.sonarrClient.SetSeasonMonitored(ctx, series.ID, season, true); err != nil {
+				return fmt.Errorf("failed to monitor season %d: %w", season, err)
+			}
+			if !search || filesRestored {
+				continue
+			}
+			if err := c.sonarrClient.SearchSeason(ctx, series.ID, season); err != nil {
+				return fmt.Errorf("failed to search season %d: %w", season, err)
+			}
+		}
+		if filesRestored {
+			if err := c.sonarrClient.RescanSeries(ctx, series.ID); err != nil {
+				return fmt.Errorf("failed to rescan series: %w", err)
+			}
+		}
+	case entry.Action == audit.ActionUnmonitor || entry.Action == audit.ActionUnmonitorAndDeleteFiles:
+		// The title is still in Sonarr/Radarr, only monitor it again, and
+		// rescan or search for its files if they were deleted
+		search = search && entry.Action == audit.ActionUnmonitorAndDeleteFiles
+		if err := c.remonitor(ctx, entry, search, filesRestored); err != nil {
+			return err
+		}
+	case entry.Action == audit.ActionDowngrade:
+		if err := c.revertDowngrade(ctx, entry, search, filesRestored); err != nil {
+			return err
+		}
+	case entry.Type == "Series":
+		_, err := c.sonarrClient.AddSeries(ctx, entry.TVDBID, sonarr.AddSeriesOptions{
+			QualityProfileID:  arr.QualityProfileID,
+			LanguageProfileID: arr.LanguageProfileID,
+			RootFolderPath:    arr.RootFolderPath,
+			Monitored:         arr.Monitored,
+			SeasonFolder:      arr.SeasonFolder,
+			SeriesType:        arr.SeriesType,
+			Tags:              arr.Tags,
+			Search:            search,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to add series to Sonarr: %w", err)
+		}
+	case entry.Type == "Movie":
+		_, err := c.radarrClient.AddMovie(ctx, entry.TMDBID, radarr.AddMovieOptions{
+			QualityProfileID:    arr.QualityProfileID,
+			RootFolderPath:      arr.RootFolderPath,
+			Monitored:           arr.Monitored,
+			MinimumAvailability: arr.MinimumAvailability,
+			Tags:                arr.Tags,
+			Search:              search,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to add movie to Radarr: %w", err)
+		}
+	default:
+		return fmt.Errorf("cannot restore %s of type %q", entry.Name, entry.Type)
+	}
+
+	return nil
+}
+
+// remonitor monitors a title that was unmonitored. If its files were deleted,
+// it rescans them when they came back from the recycle bin, or searches for
+// them again.
+func (c *cleaner) remonitor(ctx context.Context, entry audit.Entry, search, rescan bool) error {
+	switch entry.Type {
+	case "Series":
+		series, err := c.sonarrClient.GetSeriesByTVDBID(ctx, entry.TVDBID)
+		if err != nil {
+			return fmt.Errorf("failed to find series in Sonarr: %w", err)
+		}
+		if err := c.sonarrClient.SetSeriesMonitored(ctx, series.ID, true); err != nil {
+			return fmt.Errorf("failed to monitor series: %w", err)
+		}
+		if rescan {
+			return c.sonarrClient.RescanSeries(ctx, series.ID)
+		}
+		if search {
+			return c.sonarrClient.SearchSeries(ctx, series.ID)
+		}
+	case "Movie":
+		movie, err := c.radarrClient.GetMovieByTMDBID(ctx, entry.TMDBID)
+		if err != nil {
+			return fmt.Errorf("failed to find movie in Radarr: %w", err)
+		}
+		if err := c.radarrClient.SetMovieMonitored(ctx, movie.ID, true); err != nil {
+			return fmt.Errorf("failed to monitor movie: %w", err)
+		}
+		if rescan {
+			return c.radarrClient.RescanMovie(ctx, movie.ID)
+		}
+		if search {
+			return c.radarrClient.SearchMovie(ctx, movie.ID)
+		}
+	default:
+		return fmt.Errorf("cannot restore %s of type %q", entry.Name, entry.Type)
+	}
+	return nil
+}
+
+// revertDowngrade switches a downgraded title back to its original quality
+// profile. Originals that came back from the recycle bin are rescanned instead
+// of searched for.
+func (c *cleaner) revertDowngrade(ctx context.Context, entry audit.Entry, search, rescan bool) error {
+	switch entry.Type {
+	case "Series":
+		series, err := c.sonarrClient.GetSeriesByTVDBID(ctx, entry.TVDBID)
+		if err != nil {
+			return fmt.Errorf("failed to find series in Sonarr: %w", err)
+		}
+		if err := c.sonarrClient.SetSeriesQualityProfile(ctx, series.ID, entry.Arr.QualityProfileID); err != nil {
+			return fmt.Errorf("failed to change quality profile: %w", err)
+		}
+		if rescan {
+			return c.sonarrClient.RescanSeries(ctx, series.ID)
+		}
+		if search {
+			return c.sonarrClient.SearchSeries(ctx, series.ID)
+		}
+	case "Movie":
+		movie, err := c.radarrClient.GetMovieByTMDBID(ctx, entry.TMDBID)
+		if err != nil {
+			return fmt.Errorf("failed to find movie in Radarr: %w", err)
+		}
+		if err := c.radarrClient.SetMovieQualityProfile(ctx, movie.ID, entry.Arr.QualityProfileID); err != nil {
+			return fmt.Errorf("failed to change quality profile: %w", err)
+		}
+		if rescan {
+			return c.radarrClient.RescanMovie(ctx, movie.ID)
+		}
+		if search {
+			return c.radarrClient.SearchMovie(ctx, movie.ID)
+		}
+	default:
+		return fmt.Errorf("cannot restore %s of type %q", entry.Name, entry.Type)
+	}
+	return nil
+}
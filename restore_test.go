@@ -0,0 +1,146 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alex4108/jellycleaner/internal/audit"
+	"github.com/alex4108/jellycleaner/internal/jellyfin"
+	"github.com/alex4108/jellycleaner/internal/state"
+)
+
+func TestFindRestorable(t *testing.T) {
+	arr := &audit.ArrEntity{Service: "sonarr", Title: "The Expanse"}
+	seasonEntry := func(action string, season int) audit.Entry {
+		return audit.Entry{Action: action, Name: "The Expanse - Season " + strconv.Itoa(season), Type: "Season", TVDBID: "280619", Seasons: []int{season}, Arr: arr}
+	}
+	seriesEntry := func(action string) audit.Entry {
+		return audit.Entry{Action: action, Name: "The Expanse", Type: "Series", TVDBID: "280619", Arr: arr}
+	}
+
+	tests := []struct {
+		name    string
+		entries []audit.Entry
+		query   string
+		want    [][]int // Seasons of each returned entry
+		wantErr bool
+	}{
+		{
+			name:    "whole series",
+			entries: []audit.Entry{seriesEntry(audit.ActionDelete)},
+			query:   "280619",
+			want:    [][]int{nil},
+		},
+		{
+			name:    "whole series already restored",
+			entries: []audit.Entry{seriesEntry(audit.ActionDelete), seriesEntry(audit.ActionRestore)},
+			query:   "The Expanse",
+			wantErr: true,
+		},
+		{
+			name: "every deleted season",
+			entries: []audit.Entry{
+				seasonEntry(audit.ActionDeleteSeason, 1),
+				seasonEntry(audit.ActionDeleteSeason, 2),
+				seasonEntry(audit.ActionDeleteSeason, 3),
+			},
+			query: "280619",
+			want:  [][]int{{3}, {2}, {1}},
+		},
+		{
+			name: "restored season doesn't block the others",
+			entries: []audit.Entry{
+				seasonEntry(audit.ActionDeleteSeason, 1),
+				seasonEntry(audit.ActionDeleteSeason, 2),
+				seasonEntry(audit.ActionRestore, 2),
+			},
+			query: "The Expanse",
+			want:  [][]int{{1}},
+		},
+		{
+			name: "season deleted again after a restore",
+			entries: []audit.Entry{
+				seasonEntry(audit.ActionDeleteSeason, 1),
+				seasonEntry(audit.ActionRestore, 1),
+				seasonEntry(audit.ActionDeleteSeason, 1),
+			},
+			query: "280619",
+			want:  [][]int{{1}},
+		},
+		{
+			name: "all seasons restored",
+			entries: []audit.Entry{
+				seasonEntry(audit.ActionDeleteSeason, 1),
+				seasonEntry(audit.ActionRestore, 1),
+			},
+			query:   "280619",
+			wantErr: true,
+		},
+		{
+			name: "seasons after an earlier series deletion",
+			entries: []audit.Entry{
+				seriesEntry(audit.ActionDelete),
+				seasonEntry(audit.ActionDeleteSeason, 1),
+			},
+			query: "280619",
+			want:  [][]int{{1}},
+		},
+		{
+			name: "single season by its own name",
+			entries: []audit.Entry{
+				seasonEntry(audit.ActionDeleteSeason, 1),
+				seasonEntry(audit.ActionDeleteSeason, 2),
+			},
+			query: "The Expanse - Season 1",
+			want:  [][]int{{1}},
+		},
+		{
+			name:    "not found",
+			entries: []audit.Entry{seriesEntry(audit.ActionDelete)},
+			query:   "Firefly",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			found, err := findRestorable(tt.entries, tt.query)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("findRestorable() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var got [][]int
+			for _, entry := range found {
+				got = append(got, entry.Seasons)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("findRestorable() seasons = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProtectRestored(t *testing.T) {
+	store, err := state.Open(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &cleaner{store: store}
+	until := time.Now().AddDate(0, 0, 30)
+
+	c.protectRestored(audit.Entry{Action: audit.ActionDelete, Name: "Old Movie", Type: "Movie", TMDBID: "11"}, until)
+	c.protectRestored(audit.Entry{Action: audit.ActionDeleteSeason, Name: "Old Show - Season 2", Type: "Season", TVDBID: "81189", Seasons: []int{2}}, until)
+
+	// The title comes back as a new Jellyfin item
+	if !c.protected(jellyfin.Item{ID: "new", Type: "Movie", ExternalID: "11"}) {
+		t.Error("restored movie is not protected")
+	}
+	if c.protected(jellyfin.Item{ID: "other", Type: "Movie", ExternalID: "12"}) {
+		t.Error("unrelated movie is protected")
+	}
+	if _, ok := store.Protected(seasonKey("81189", 2), time.Now()); !ok {
+		t.Error("restored season is not protected")
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/alex4108/jellycleaner/config"
+	"github.com/alex4108/jellycleaner/internal/audit"
+	"github.com/alex4108/jellycleaner/internal/plan"
+	"github.com/alex4108/jellycleaner/internal/radarr"
+	"github.com/alex4108/jellycleaner/internal/sonarr"
+	"github.com/alex4108/jellycleaner/internal/state"
+)
+
+// unmonitorContent stops Sonarr/Radarr from grabbing or upgrading a title,
+// and deletes its files if the library's action asks for it
+func (c *cleaner) unmonitorContent(ctx context.Context, record state.Record, library *config.Library) error {
+	item := recordItem(record)
+	deleteFiles := library.Action == config.ActionUnmonitorAndDeleteFiles
+
+	if c.plan != nil {
+		action := plan.ActionUnmonitor
+		if deleteFiles {
+			action = plan.ActionUnmonitorAndDeleteFiles
+		}
+		c.plan.Add(plan.Entry{
+			ItemID:         item.ID,
+			Item:           item.Name,
+			Type:           item.Type,
+			Library:        library.Name,
+			Rule:           record.Rule,
+			Reason:         record.Reason,
+			Action:         action,
+			ExpirationDate: record.DueAt.Format("2006-01-02"),
+		})
+		return nil
+	}
+
+	action := audit.ActionUnmonitor
+	if deleteFiles {
+		action = audit.ActionUnmonitorAndDeleteFiles
+	}
+	entry := c.newAuditEntry(ctx, record, library, action)
+
+	switch item.Type {
+	case "Series":
+		series, err := c.sonarrClient.GetSeriesByTVDBID(ctx, item.ExternalID)
+		if err != nil {
+			return fmt.Errorf("failed to find series in Sonarr: %w", err)
+		}
+		entry.Arr = sonarrEntity(series)
+		if err := c.sonarrClient.SetSeriesMonitored(ctx, series.ID, false); err != nil {
+			return fmt.Errorf("failed to unmonitor series in Sonarr: %w", err)
+		}
+
+		if deleteFiles {
+			entry.RecyclePath, err = c.deleteEpisodeFiles(ctx, series)
+			if err != nil {
+				return recycledError(err, entry.RecyclePath)
+			}
+		}
+	case "Movie":
+		movie, err := c.radarrClient.GetMovieByTMDBID(ctx, item.ExternalID)
+		if err != nil {
+			return fmt.Errorf("failed to find movie in Radarr: %w", err)
+		}
+		entry.Arr = radarrEntity(movie)
+		if err := c.radarrClient.SetMovieMonitored(ctx, movie.ID, false); err != nil {
+			return fmt.Errorf("failed to unmonitor movie in Radarr: %w", err)
+		}
+
+		if deleteFiles {
+			entry.RecyclePath, err = c.deleteMovieFiles(ctx, movie)
+			if err != nil {
+				return recycledError(err, entry.RecyclePath)
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported item type %q", item.Type)
+	}
+
+	c.appendAudit(entry)
+
+	if deleteFiles {
+		// The files are gone, so let the title be requested again
+		if err := c.jellyseerrClient.DeleteMediaFromJellyseerr(ctx, jellyseerrMediaType(item.Type), item.ExternalID); err != nil {
+			log.Warnf("Failed to remove content (%s) from Jellyseerr: %v", item.Name, err)
+		}
+	}
+
+	log.Infof("Successfully unmonitored: %s", item.Name)
+	return nil
+}
+
+// deleteEpisodeFiles deletes the episode files of a series through Sonarr,
+// only those of the given seasons if any are given. In recycle mode the files
+// are moved into the recycle bin first, and their location there is returned.
+func (c *cleaner) deleteEpisodeFiles(ctx context.Context, series *sonarr.Series, seasons ...int) (string, error) {
+	files, err := c.sonarrClient.GetEpisodeFiles(ctx, series.ID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get episode files: %w", err)
+	}
+
+	var selected []sonarr.EpisodeFile
+	var paths []string
+	for _, file := range files {
+		if len(seasons) > 0 && !containsInt(seasons, file.SeasonNumber) {
+			continue
+		}
+		selected = append(selected, file)
+		paths = append(paths, file.Path)
+	}
+
+	recycled, err := c.recycleFiles(series.Path, paths)
+	if err != nil {
+		return "", err
+	}
+	for _, file := range selected {
+		if err := c.sonarrClient.DeleteEpisodeFile(ctx, file.ID); err != nil {
+			return recycled, fmt.Errorf("failed to delete episode file %s: %w", file.Path, err)
+		}
+	}
+	return recycled, nil
+}
+
+// deleteMovieFiles deletes the files of a movie through Radarr. In recycle
+// mode the files are moved into the recycle bin first, and their location
+// there is returned.
+func (c *cleaner) deleteMovieFiles(ctx context.Context, movie *radarr.Movie) (string, error) {
+	files, err := c.radarrClient.GetMovieFiles(ctx, movie.ID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get movie files: %w", err)
+	}
+
+	paths := make([]string, len(files))
+	for i, file := range files {
+		paths[i] = file.Path
+	}
+
+	recycled, err := c.recycleFiles(movie.FilePath, paths)
+	if err != nil {
+		return "", err
+	}
+	for _, file := range files {
+		if err := c.radarrClient.DeleteMovieFile(ctx, file.ID); err != nil {
+			return recycled, fmt.Errorf("failed to delete movie file %s: %w", file.Path, err)
+		}
+	}
+	return recycled, nil
+}
+
+// recycledError adds where files were recycled to the error of an action
+// that failed after moving them, since no audit entry records it
+func recycledError(err error, recyclePath string) error {
+	if recyclePath == "" {
+		return err
+	}
+	return fmt.Errorf("%w (files recycled so far are in %s)", err, recyclePath)
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
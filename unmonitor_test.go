@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alex4108/jellycleaner/config"
+	"github.com/alex4108/jellycleaner/internal/audit"
+	"github.com/alex4108/jellycleaner/internal/httpx"
+	"github.com/alex4108/jellycleaner/internal/jellyseerr"
+	"github.com/alex4108/jellycleaner/internal/radarr"
+	"github.com/alex4108/jellycleaner/internal/state"
+)
+
+func TestUnmonitorRecyclesFiles(t *testing.T) {
+	dir := t.TempDir()
+	movieDir := filepath.Join(dir, "movies", "Old Movie")
+	moviePath := filepath.Join(movieDir, "old.mkv")
+	if err := os.MkdirAll(movieDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(moviePath, []byte("movie"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var commands []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var response interface{} = map[string]interface{}{}
+		switch r.Method + " " + r.URL.Path {
+		case "GET /api/v3/movie":
+			response = []map[string]interface{}{{"id": 1, "title": "Old Movie", "tmdbId": 11, "path": movieDir}}
+		case "GET /api/v3/moviefile":
+			response = []map[string]interface{}{{"id": 7, "movieId": 1, "path": moviePath}}
+		case "DELETE /api/v3/moviefile/7":
+			// Radarr would delete the file for good
+			if _, err := os.Stat(moviePath); err == nil {
+				t.Error("file was deleted through Radarr before it was recycled")
+			}
+		case "POST /api/v3/command":
+			var body map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&body)
+			mu.Lock()
+			commands = append(commands, body["name"].(string))
+			mu.Unlock()
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	radarrClient, err := radarr.NewClient(server.URL, "key", httpx.Options{MaxRetries: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jellyseerrClient, err := jellyseerr.NewClient(server.URL, "key", httpx.Options{MaxRetries: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{}
+	cfg.Recycle = config.RecycleConfig{Enabled: true, Path: filepath.Join(dir, "recycle"), RetentionDays: 30}
+	c := &cleaner{
+		cfg:              cfg,
+		radarrClient:     radarrClient,
+		jellyseerrClient: jellyseerrClient,
+		auditLog:         audit.Open(filepath.Join(dir, "audit.jsonl")),
+		requestIndex:     jellyseerr.NewRequestIndex(nil),
+	}
+	library := &config.Library{Name: "Movies", Action: config.ActionUnmonitorAndDeleteFiles}
+	record := state.Record{ItemID: "m1", Name: "Old Movie", Type: "Movie", ExternalID: "11", Library: "Movies", DueAt: time.Now()}
+
+	if err := c.unmonitorContent(context.Background(), record, library); err != nil {
+		t.Fatalf("unmonitorContent: %v", err)
+	}
+
+	entries, err := c.auditLog.Entries()
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("want 1 audit entry, got %v (%v)", entries, err)
+	}
+	entry := entries[0]
+	if _, err := os.Stat(filepath.Join(entry.RecyclePath, "old.mkv")); err != nil {
+		t.Fatalf("file isn't in the recycle bin: %v", err)
+	}
+
+	if err := c.restore(context.Background(), entry, true); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if _, err := os.Stat(moviePath); err != nil {
+		t.Errorf("file wasn't moved back: %v", err)
+	}
+	if len(commands) != 1 || commands[0] != "RescanMovie" {
+		t.Errorf("want the restored file rescanned instead of searched, got commands %v", commands)
+	}
+}
+
+func TestUnmonitorAuditsOnlyOnSuccess(t *testing.T) {
+	var mu sync.Mutex
+	failDelete := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		var response interface{} = map[string]interface{}{}
+		switch r.Method + " " + r.URL.Path {
+		case "GET /api/v3/movie":
+			response = []map[string]interface{}{{"id": 1, "title": "Old Movie", "tmdbId": 11, "path": "/movies/Old Movie"}}
+		case "GET /api/v3/moviefile":
+			response = []map[string]interface{}{{"id": 7, "movieId": 1, "path": "/movies/Old Movie/old.mkv"}}
+		case "DELETE /api/v3/moviefile/7":
+			if failDelete {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	radarrClient, err := radarr.NewClient(server.URL, "key", httpx.Options{MaxRetries: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jellyseerrClient, err := jellyseerr.NewClient(server.URL, "key", httpx.Options{MaxRetries: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &cleaner{
+		cfg:              &config.Config{},
+		radarrClient:     radarrClient,
+		jellyseerrClient: jellyseerrClient,
+		auditLog:         audit.Open(filepath.Join(t.TempDir(), "audit.jsonl")),
+		requestIndex:     jellyseerr.NewRequestIndex(nil),
+	}
+	library := &config.Library{Name: "Movies", Action: config.ActionUnmonitorAndDeleteFiles}
+	record := state.Record{ItemID: "m1", Name: "Old Movie", Type: "Movie", ExternalID: "11", Library: "Movies", DueAt: time.Now()}
+
+	if err := c.unmonitorContent(context.Background(), record, library); err == nil {
+		t.Fatal("unmonitorContent succeeded although the file couldn't be deleted")
+	}
+	if entries, _ := c.auditLog.Entries(); len(entries) != 0 {
+		t.Fatalf("failed action wrote %d audit entries, want none", len(entries))
+	}
+
+	mu.Lock()
+	failDelete = false
+	mu.Unlock()
+	if err := c.unmonitorContent(context.Background(), record, library); err != nil {
+		t.Fatalf("unmonitorContent: %v", err)
+	}
+	if entries, _ := c.auditLog.Entries(); len(entries) != 1 {
+		t.Errorf("want 1 audit entry after the retry succeeded, got %d", len(entries))
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// forEach calls fn with every index in [0, n), running up to the configured
+// number of workers concurrently, and returns once all calls have finished.
+// Indexes that haven't started when ctx is cancelled are skipped. fn must only
+// write to its own index of any shared slice.
+func (c *cleaner) forEach(ctx context.Context, n int, fn func(i int)) {
+	workers := c.cfg.Workers
+	if workers > n {
+		workers = n
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				// An index may have been handed out just before ctx was cancelled
+				if ctx.Err() != nil {
+					continue
+				}
+				fn(i)
+			}
+		}()
+	}
+
+	for i := 0; i < n && ctx.Err() == nil; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alex4108/jellycleaner/config"
+)
+
+func TestForEachRunsEveryIndexOnce(t *testing.T) {
+	c := &cleaner{cfg: &config.Config{Workers: 3}}
+
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	calls := make([]int32, 30)
+	c.forEach(context.Background(), len(calls), func(i int) {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+
+		atomic.AddInt32(&calls[i], 1)
+		time.Sleep(2 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+	})
+
+	for i, n := range calls {
+		if n != 1 {
+			t.Errorf("index %d ran %d times, want once", i, n)
+		}
+	}
+	if maxRunning > 3 {
+		t.Errorf("%d calls ran at once, want at most 3", maxRunning)
+	}
+}
+
+func TestForEachStopsOnCancel(t *testing.T) {
+	// A single worker makes the order deterministic
+	c := &cleaner{cfg: &config.Config{Workers: 1}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var ran []int
+	c.forEach(ctx, 20, func(i int) {
+		ran = append(ran, i)
+		if i == 4 {
+			cancel()
+		}
+	})
+
+	if len(ran) != 5 || ran[4] != 4 {
+		t.Errorf("ran %v, want indexes 0 to 4", ran)
+	}
+}